@@ -0,0 +1,513 @@
+// Package analysis answers questions about filters without evaluating them:
+// whether a filter can ever match, and whether a filter implies another.
+//
+// Each distinct call, like rec('Level'), or field is a variable. The
+// comparisons, BETWEEN and IN lists of a variable with literals are solved
+// over numbers or strings: rec('Level') > 10 implies rec('Level') > 5. Numbers
+// are taken as reals, so rec('Level') > 1 and rec('Level') < 2 can match.
+// The other conditions, like LIKE or comparisons between calls, are taken
+// as boolean variables of their own, so only their negations contradict
+// them. The variables are assumed not to be null.
+package analysis
+
+import (
+	"math"
+	"sort"
+	"strings"
+
+	fql "github.com/lennon-guan/filterql"
+)
+
+// maxSize limits the DNF the filters are converted to, beyond it the verdict
+// is Unknown.
+const maxSize = 4096
+
+type Verdict int
+
+const (
+	// Unknown is when the analysis gives up, or when the conditions taken as
+	// boolean variables share variables with others.
+	Unknown Verdict = iota
+	Yes
+	No
+)
+
+func (v Verdict) String() string {
+	switch v {
+	case Yes:
+		return "yes"
+	case No:
+		return "no"
+	}
+	return "unknown"
+}
+
+// Assignment gives the values of the variables by their source, like
+// rec('Level') or user.name. A condition taken as a boolean variable is
+// given by its source too, with a bool value.
+type Assignment map[string]any
+
+type Result struct {
+	Verdict Verdict
+	// Witness is an assignment matching the filter for Satisfiable, or a
+	// counterexample matching a but not b for Implies. It's only set when
+	// one is found for sure.
+	Witness Assignment
+}
+
+// Satisfiable tells if cond can match, with an assignment it matches.
+func Satisfiable(cond fql.BoolAst) Result {
+	cond, err := fql.Rewrite(fql.Optimize(cond), expandBetween)
+	if err != nil {
+		return Result{Verdict: Unknown}
+	}
+	dnf, err := fql.Normalize(cond, fql.DNF, maxSize)
+	if err != nil {
+		return Result{Verdict: Unknown}
+	}
+	var clauses []fql.BoolAst
+	switch c := dnf.(type) {
+	case *fql.ORs:
+		clauses = c.Children
+	case *fql.Literal:
+		if c.Value == true {
+			return Result{Verdict: Yes, Witness: Assignment{}}
+		}
+		return Result{Verdict: No}
+	default:
+		clauses = []fql.BoolAst{dnf}
+	}
+	verdict := No
+	for _, clause := range clauses {
+		atoms := []fql.BoolAst{clause}
+		if and, is := clause.(*fql.ANDs); is {
+			atoms = and.Children
+		}
+		switch v, witness := solveClause(atoms); v {
+		case Yes:
+			return Result{Verdict: Yes, Witness: witness}
+		case Unknown:
+			verdict = Unknown
+		}
+	}
+	return Result{Verdict: verdict}
+}
+
+// Implies tells if every env matched by a is matched by b. When it's not the
+// case, the witness is an assignment matching a but not b.
+func Implies(a, b fql.BoolAst) Result {
+	r := Satisfiable(&fql.ANDs{Children: []fql.BoolAst{a, b.Not()}})
+	switch r.Verdict {
+	case No:
+		return Result{Verdict: Yes}
+	case Yes:
+		return Result{Verdict: No, Witness: r.Witness}
+	}
+	return Result{Verdict: Unknown}
+}
+
+// expandBetween replaces x between a and b by x >= a and x <= b, so the
+// bounds are constraints too.
+func expandBetween(node fql.PrintableAst) (fql.PrintableAst, error) {
+	c, is := node.(*fql.Between)
+	if !is {
+		return node, nil
+	}
+	lower, isLit1 := c.Lower.(*fql.Literal)
+	upper, isLit2 := c.Upper.(*fql.Literal)
+	if !isLit1 || !isLit2 {
+		return node, nil
+	}
+	lowerOp, upperOp := fql.TOKEN_OP_GE, fql.TOKEN_OP_LE
+	if c.Exclusive {
+		lowerOp, upperOp = fql.TOKEN_OP_GT, fql.TOKEN_OP_LT
+	}
+	l, u := compareOf(c.Call, lowerOp, lower.Value), compareOf(c.Call, upperOp, upper.Value)
+	if l == nil || u == nil {
+		return node, nil
+	}
+	var cond fql.BoolAst = &fql.ANDs{Children: []fql.BoolAst{l, u}}
+	if c.NotBetween {
+		cond = &fql.NOT{Child: cond}
+	}
+	return cond, nil
+}
+
+func compareOf(call fql.Call, op int, value any) fql.BoolAst {
+	switch v := value.(type) {
+	case int:
+		return &fql.Compare[int]{Call: call, Op: op, Target: v}
+	case uint64:
+		return &fql.Compare[uint64]{Call: call, Op: op, Target: v}
+	case float64:
+		return &fql.Compare[float64]{Call: call, Op: op, Target: v}
+	case string:
+		return &fql.Compare[string]{Call: call, Op: op, Target: v}
+	}
+	return nil
+}
+
+// constraint is a comparison of a variable with literals.
+type constraint struct {
+	op     int
+	values []any
+}
+
+const (
+	opEq = iota
+	opNe
+	opLt
+	opLe
+	opGt
+	opGe
+	opIn
+	opNotIn
+)
+
+func compareOp(op int) int {
+	switch op {
+	case fql.TOKEN_OP_NE:
+		return opNe
+	case fql.TOKEN_OP_LT:
+		return opLt
+	case fql.TOKEN_OP_LE:
+		return opLe
+	case fql.TOKEN_OP_GT:
+		return opGt
+	case fql.TOKEN_OP_GE:
+		return opGe
+	}
+	return opEq
+}
+
+func inOp(not bool) int {
+	if not {
+		return opNotIn
+	}
+	return opIn
+}
+
+// variable is a call or a field and its constraints.
+type variable struct {
+	node        fql.PrintableAst
+	constraints []constraint
+}
+
+// constraintOf returns the variable an atom constrains, its source and the
+// constraint, or false if it's not a constraint on a variable.
+func constraintOf(atom fql.BoolAst) (fql.PrintableAst, string, constraint, bool) {
+	switch c := atom.(type) {
+	case fql.CallCompare:
+		return atom, callKey(c.Name(), c.Args()), constraint{compareOp(c.Op()), []any{c.Target()}}, true
+	case fql.CallIn:
+		return atom, callKey(c.Name(), c.Args()), constraint{inOp(c.Negated()), c.Choices()}, true
+	case *fql.Compare[int]:
+		return c.Call, exprKey(c.Call), constraint{compareOp(c.Op), []any{c.Target}}, true
+	case *fql.Compare[uint64]:
+		return c.Call, exprKey(c.Call), constraint{compareOp(c.Op), []any{c.Target}}, true
+	case *fql.Compare[float64]:
+		return c.Call, exprKey(c.Call), constraint{compareOp(c.Op), []any{c.Target}}, true
+	case *fql.Compare[string]:
+		return c.Call, exprKey(c.Call), constraint{compareOp(c.Op), []any{c.Target}}, true
+	case *fql.In[int]:
+		return c.Call, exprKey(c.Call), constraint{inOp(c.NotIn), c.ChoiceValues()}, true
+	case *fql.In[uint64]:
+		return c.Call, exprKey(c.Call), constraint{inOp(c.NotIn), c.ChoiceValues()}, true
+	case *fql.In[float64]:
+		return c.Call, exprKey(c.Call), constraint{inOp(c.NotIn), c.ChoiceValues()}, true
+	case *fql.In[string]:
+		return c.Call, exprKey(c.Call), constraint{inOp(c.NotIn), c.ChoiceValues()}, true
+	}
+	return nil, "", constraint{}, false
+}
+
+// named is a call by its name.
+type named interface {
+	Name() string
+	Args() []fql.EvalAst
+}
+
+func callKey(name string, args []fql.EvalAst) string {
+	items := make([]string, len(args))
+	for i, arg := range args {
+		items[i] = arg.String()
+	}
+	return name + "(" + strings.Join(items, ", ") + ")"
+}
+
+// exprKey returns the source of an operand, without the negation of a call
+// or a field used as a condition.
+func exprKey(c fql.EvalAst) string {
+	switch c := c.(type) {
+	case fql.MethodCall:
+		return callKey(c.Name(), c.Args())
+	case *fql.Field:
+		return strings.TrimPrefix(c.String(), "not ")
+	}
+	return c.String()
+}
+
+// baseVars adds the calls with literal arguments only and the fields found
+// in node to vars.
+func baseVars(node fql.PrintableAst, vars map[string]bool) {
+	fql.Inspect(node, func(n fql.PrintableAst) bool {
+		switch c := n.(type) {
+		case *fql.Field:
+			vars[exprKey(c)] = true
+		case named:
+			for _, arg := range c.Args() {
+				if !isLiteral(arg) {
+					return true
+				}
+			}
+			vars[callKey(c.Name(), c.Args())] = true
+			return false
+		}
+		return true
+	})
+}
+
+func isLiteral(node fql.EvalAst) bool {
+	_, is := node.(*fql.Literal)
+	return is
+}
+
+// solveClause solves an AND of atoms.
+func solveClause(atoms []fql.BoolAst) (Verdict, Assignment) {
+	var (
+		keys    []string
+		vars    = map[string]*variable{}
+		opaques = map[string]bool{}
+		nodes   = map[string]fql.PrintableAst{}
+	)
+	for _, atom := range atoms {
+		if node, key, c, ok := constraintOf(atom); ok {
+			v := vars[key]
+			if v == nil {
+				v = &variable{node: node}
+				vars[key] = v
+				keys = append(keys, key)
+			}
+			v.constraints = append(v.constraints, c)
+			continue
+		}
+		// a condition and its negation share the same variable
+		key, value := atom.String(), true
+		if not := atom.Not().String(); not < key {
+			key, value = not, false
+		}
+		if prev, found := opaques[key]; found && prev != value {
+			return No, nil
+		} else if !found {
+			opaques[key] = value
+			nodes[key] = atom
+		}
+	}
+	witness := Assignment{}
+	for _, key := range keys {
+		value, ok := vars[key].solve()
+		if !ok {
+			return No, nil
+		}
+		witness[key] = value
+		nodes[key] = vars[key].node
+	}
+	for key, value := range opaques {
+		witness[key] = value
+	}
+	// the variables are solved apart, which only holds if they don't share
+	// any call or field
+	owners := map[string]string{}
+	for key, node := range nodes {
+		bases := map[string]bool{}
+		baseVars(node, bases)
+		for base := range bases {
+			if owner, found := owners[base]; found && owner != key {
+				return Unknown, nil
+			}
+			owners[base] = key
+		}
+	}
+	return Yes, witness
+}
+
+// solve returns a value satisfying all the constraints of v.
+func (v *variable) solve() (any, bool) {
+	hasNum, hasStr := false, false
+	for _, c := range v.constraints {
+		for _, value := range c.values {
+			if _, is := value.(string); is {
+				hasStr = true
+			} else {
+				hasNum = true
+			}
+		}
+	}
+	switch {
+	case hasNum && hasStr:
+		// a value of one type is never equal nor ordered with the other
+		return nil, false
+	case hasStr:
+		return solveStr(v.constraints)
+	}
+	return solveNum(v.constraints)
+}
+
+func toFloat(v any) float64 {
+	switch n := v.(type) {
+	case int:
+		return float64(n)
+	case uint64:
+		return float64(n)
+	case float64:
+		return n
+	}
+	return math.NaN()
+}
+
+func satisfies[T float64 | string](x T, cs []constraint, conv func(any) T) bool {
+	for _, c := range cs {
+		var ok bool
+		switch c.op {
+		case opEq, opIn:
+			for _, value := range c.values {
+				if x == conv(value) {
+					ok = true
+					break
+				}
+			}
+		case opNe, opNotIn:
+			ok = true
+			for _, value := range c.values {
+				if x == conv(value) {
+					ok = false
+					break
+				}
+			}
+		case opLt:
+			ok = x < conv(c.values[0])
+		case opLe:
+			ok = x <= conv(c.values[0])
+		case opGt:
+			ok = x > conv(c.values[0])
+		case opGe:
+			ok = x >= conv(c.values[0])
+		}
+		if !ok {
+			return false
+		}
+	}
+	return true
+}
+
+// candidates returns the values of the first = or IN constraint, which any
+// solution is one of.
+func candidates(cs []constraint) ([]any, bool) {
+	for _, c := range cs {
+		if c.op == opEq || c.op == opIn {
+			return c.values, true
+		}
+	}
+	return nil, false
+}
+
+func excluded(cs []constraint) int {
+	n := 0
+	for _, c := range cs {
+		if c.op == opNe || c.op == opNotIn {
+			n += len(c.values)
+		}
+	}
+	return n
+}
+
+func solveNum(cs []constraint) (any, bool) {
+	if values, found := candidates(cs); found {
+		for _, value := range values {
+			if satisfies(toFloat(value), cs, toFloat) {
+				return value, true
+			}
+		}
+		return nil, false
+	}
+	lo, hi := math.Inf(-1), math.Inf(1)
+	for _, c := range cs {
+		switch f := toFloat(c.values[0]); c.op {
+		case opGt, opGe:
+			lo = math.Max(lo, f)
+		case opLt, opLe:
+			hi = math.Min(hi, f)
+		}
+	}
+	// n+1 tries find a value unless n values are excluded, integers first
+	n := excluded(cs) + 1
+	var tries []float64
+	switch {
+	case !math.IsInf(lo, 0):
+		start := math.Floor(lo)
+		for i := 0; i <= n; i++ {
+			tries = append(tries, start+float64(i))
+		}
+	case !math.IsInf(hi, 0):
+		start := math.Ceil(hi)
+		for i := 0; i <= n; i++ {
+			tries = append(tries, start-float64(i))
+		}
+	default:
+		for i := 0; i < n; i++ {
+			tries = append(tries, float64(i))
+		}
+	}
+	if !math.IsInf(lo, 0) && !math.IsInf(hi, 0) {
+		for i := 1; i <= n; i++ {
+			tries = append(tries, lo+(hi-lo)*float64(i)/float64(n+1))
+		}
+	}
+	for _, x := range tries {
+		if satisfies(x, cs, toFloat) {
+			if x == math.Trunc(x) && math.Abs(x) < 1<<53 {
+				return int(x), true
+			}
+			return x, true
+		}
+	}
+	return nil, false
+}
+
+func toStr(v any) string {
+	s, _ := v.(string)
+	return s
+}
+
+func solveStr(cs []constraint) (any, bool) {
+	if values, found := candidates(cs); found {
+		for _, value := range values {
+			if satisfies(toStr(value), cs, toStr) {
+				return value, true
+			}
+		}
+		return nil, false
+	}
+	// the least strings from the greatest lower bound
+	base := ""
+	for _, c := range cs {
+		if s := toStr(c.values[0]); (c.op == opGt || c.op == opGe) && s > base {
+			base = s
+		}
+	}
+	n := excluded(cs) + 1
+	tries := []string{base}
+	for i := 0; i < n; i++ {
+		tries = append(tries, base+string(rune('a'+i%26))+strings.Repeat("a", i/26))
+	}
+	for i := 1; i <= n; i++ {
+		tries = append(tries, base+strings.Repeat("\x00", i))
+	}
+	sort.Strings(tries)
+	for _, s := range tries {
+		if satisfies(s, cs, toStr) {
+			return s, true
+		}
+	}
+	return nil, false
+}
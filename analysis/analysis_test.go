@@ -0,0 +1,121 @@
+package analysis_test
+
+import (
+	"testing"
+
+	fql "github.com/lennon-guan/filterql"
+	"github.com/lennon-guan/filterql/analysis"
+)
+
+var cfg = &fql.ParseConfig{
+	StrMethods: map[string]func(any, string) (any, error){
+		"rec": func(env any, field string) (any, error) {
+			return env.(map[string]any)[field], nil
+		},
+	},
+	Methods: map[string]fql.Method{
+		"double": {
+			Params: []fql.Type{fql.TypeInt},
+			Fn: func(env any, args []any) (any, error) {
+				return args[0].(int) * 2, nil
+			},
+		},
+	},
+}
+
+func parse(t *testing.T, query string) fql.BoolAst {
+	t.Helper()
+	cond, err := fql.Parse(query, cfg)
+	if err != nil {
+		t.Fatalf("parse %s error %+v", query, err)
+	}
+	return cond
+}
+
+// check evaluates cond on the witness, whose keys are rec('...') calls.
+func check(t *testing.T, query string, witness analysis.Assignment, expected bool) {
+	t.Helper()
+	env := map[string]any{}
+	for key, value := range witness {
+		if len(key) > 7 && key[:5] == "rec('" && key[len(key)-2:] == "')" {
+			env[key[5:len(key)-2]] = value
+		}
+	}
+	matched, err := parse(t, query).IsTrue(fql.NewContext(env))
+	if err != nil {
+		t.Errorf("%s on %v error %+v", query, witness, err)
+	} else if matched != expected {
+		t.Errorf("%s on %v expected %v", query, witness, expected)
+	}
+}
+
+func TestSatisfiable(t *testing.T) {
+	for query, expected := range map[string]analysis.Verdict{
+		"rec('Level') > 10":                                                       analysis.Yes,
+		"rec('Level') = 1 and rec('Level') = 2":                                   analysis.No,
+		"rec('Level') > 10 and rec('Level') < 5":                                  analysis.No,
+		"rec('Level') > 1 and rec('Level') < 2":                                   analysis.Yes,
+		"rec('Level') >= 2 and rec('Level') <= 2":                                 analysis.Yes,
+		"rec('Level') >= 2 and rec('Level') <= 2 and rec('Level') <> 2":           analysis.No,
+		"rec('Level') in (1, 2, 3) and rec('Level') in (3, 4)":                    analysis.Yes,
+		"rec('Level') in (1, 2) and rec('Level') not in (1, 2)":                   analysis.No,
+		"rec('Level') between 1 and 3 and rec('Level') not in (1, 2, 3)":          analysis.Yes,
+		"rec('Level') not in (1, 2, 3) and rec('Level') > 0 and rec('Level') < 4": analysis.Yes,
+		"rec('Name') > 'b' and rec('Name') < 'c'":                                 analysis.Yes,
+		"rec('Name') >= 'b' and rec('Name') < 'b'":                                analysis.No,
+		"rec('Name') = 'a' and rec('Name') > 'a'":                                 analysis.No,
+		"rec('Name') = 'a' and rec('Level') = 1":                                  analysis.Yes,
+		"rec('Name') = 'a' and rec('Name') = 1":                                   analysis.No,
+		"rec('Level') = 1 and rec('Level') = 2 or rec('Level') = 3":               analysis.Yes,
+		"rec('Name') like 'a%' and not rec('Name') like 'a%'":                     analysis.No,
+		"rec('Name') like 'a%' and rec('Level') > 1":                              analysis.Yes,
+		"rec('Name') like 'a%' and rec('Name') = 'b'":                             analysis.Unknown,
+		"double(rec('Level')) = 4 and rec('Level') = 3":                           analysis.Unknown,
+		"rec('Level') > rec('Score') and rec('Level') < rec('Score')":             analysis.Unknown,
+		"1 > 2": analysis.No,
+		"1 < 2": analysis.Yes,
+	} {
+		r := analysis.Satisfiable(parse(t, query))
+		if r.Verdict != expected {
+			t.Errorf("%s expected %v but got %v", query, expected, r.Verdict)
+			continue
+		}
+		if r.Verdict == analysis.Yes {
+			if r.Witness == nil {
+				t.Errorf("%s expected a witness", query)
+			} else if _, opaque := r.Witness["rec('Name') like 'a%'"]; !opaque {
+				check(t, query, r.Witness, true)
+			}
+		} else if r.Witness != nil {
+			t.Errorf("%s expected no witness but got %v", query, r.Witness)
+		}
+	}
+}
+
+func TestImplies(t *testing.T) {
+	for _, c := range []struct {
+		a, b     string
+		expected analysis.Verdict
+	}{
+		{"rec('Level') > 10", "rec('Level') > 5", analysis.Yes},
+		{"rec('Level') > 5", "rec('Level') > 10", analysis.No},
+		{"rec('Level') in (1, 2)", "rec('Level') < 3", analysis.Yes},
+		{"rec('Level') in (1, 2, 3)", "rec('Level') < 3", analysis.No},
+		{"rec('Level') = 2 and rec('Name') = 'a'", "rec('Level') between 1 and 3", analysis.Yes},
+		{"rec('Level') = 2 or rec('Level') = 4", "rec('Level') in (2, 4)", analysis.Yes},
+		{"rec('Name') = 'ab'", "rec('Name') >= 'a'", analysis.Yes},
+		{"rec('Name') like 'a%'", "rec('Name') like 'a%' or rec('Level') > 1", analysis.Yes},
+		{"rec('Level') > 1", "rec('Name') = 'a'", analysis.No},
+		{"rec('Name') = 'a'", "rec('Name') like 'a%'", analysis.Unknown},
+	} {
+		r := analysis.Implies(parse(t, c.a), parse(t, c.b))
+		if r.Verdict != c.expected {
+			t.Errorf("%s implies %s expected %v but got %v", c.a, c.b, c.expected, r.Verdict)
+			continue
+		}
+		if r.Verdict == analysis.No {
+			check(t, c.a, r.Witness, true)
+			check(t, c.b, r.Witness, false)
+		}
+	}
+}
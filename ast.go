@@ -1,20 +1,24 @@
 package filterql
 
 import (
+	"context"
 	"io"
-	"reflect"
+	"regexp"
+	"strings"
 )
 
 type TArg interface {
-	int | string
+	int | uint64 | float64 | string
 }
 type PrintableAst interface {
 	PrintTo(level int, out io.Writer)
+	// String returns the canonical source of the node.
+	String() string
 }
 
 type EvalAst interface {
 	PrintableAst
-	Eval(*Context) error
+	Eval(*Context) (any, error)
 }
 
 type CanNot interface {
@@ -32,13 +36,22 @@ type ANDs struct {
 }
 
 func (a *ANDs) IsTrue(ctx *Context) (bool, error) {
+	unknown := false
 	for _, child := range a.Children {
-		if rv, err := child.IsTrue(ctx); err != nil {
+		if err := ctx.canceled(); err != nil {
+			return false, err
+		}
+		if rv, err := child.IsTrue(ctx); err == ErrUnknown {
+			unknown = true
+		} else if err != nil {
 			return false, err
 		} else if !rv {
 			return false, nil
 		}
 	}
+	if unknown {
+		return false, ErrUnknown
+	}
 	return true, nil
 }
 
@@ -59,13 +72,22 @@ type ORs struct {
 }
 
 func (a *ORs) IsTrue(ctx *Context) (bool, error) {
+	unknown := false
 	for _, child := range a.Children {
-		if rv, err := child.IsTrue(ctx); err != nil {
+		if err := ctx.canceled(); err != nil {
+			return false, err
+		}
+		if rv, err := child.IsTrue(ctx); err == ErrUnknown {
+			unknown = true
+		} else if err != nil {
 			return false, err
 		} else if rv {
 			return true, nil
 		}
 	}
+	if unknown {
+		return false, ErrUnknown
+	}
 	return false, nil
 }
 
@@ -86,6 +108,7 @@ type NOT struct {
 }
 
 func (a *NOT) IsTrue(ctx *Context) (bool, error) {
+	// unknown stays unknown, which is also reported as an error
 	if r, err := a.Child.IsTrue(ctx); err != nil {
 		return false, err
 	} else {
@@ -103,17 +126,76 @@ type Call interface {
 	CanNot
 }
 
+// callee is the method a call is bound to. ctxFn is used instead of fn if
+// it's set. The results of a volatile method are never memoized.
+type callee[T any] struct {
+	name     string
+	fn       func(any, T) (any, error)
+	ctxFn    func(context.Context, any, T) (any, error)
+	volatile bool
+}
+
+func (c *callee[T]) setVolatile() {
+	c.volatile = true
+}
+
+func (c *callee[T]) isVolatile() bool {
+	return c.volatile
+}
+
+// invoke calls the method unless the evaluation is canceled. An error from a
+// method that fails because of the cancellation is reported as canceled too.
+func (c *callee[T]) invoke(ctx *Context, arg T) (any, error) {
+	if err := ctx.canceled(); err != nil {
+		return nil, err
+	}
+	var (
+		key     memoKey
+		memoize bool
+	)
+	if ctx.Memoize && !c.volatile {
+		if key, memoize = memoKeyOf(c.name, arg); memoize {
+			if ret, found := ctx.memoized(key); found {
+				return ret, nil
+			}
+		}
+	}
+	var (
+		ret any
+		err error
+	)
+	if c.ctxFn != nil {
+		ret, err = c.ctxFn(ctx.goContext(), ctx.Env, arg)
+	} else {
+		ret, err = c.fn(ctx.Env, arg)
+	}
+	if err != nil {
+		if cerr := ctx.canceled(); cerr != nil {
+			return nil, cerr
+		}
+	} else if memoize {
+		ctx.memoize(key, ret)
+	}
+	return ret, err
+}
+
+// call is a call to a method taking a single argument, which is either the
+// literal arg or the result of argCall if it's set.
 type call[T TArg] struct {
-	name string
-	arg  T
-	fn   func(any, T) (any, error)
-	not  bool
+	callee[T]
+	arg     T
+	argCall Call
+	not     bool
 }
 
 func newCall[T TArg](
 	fnMap map[string]func(any, T) (any, error),
+	ctxFnMap map[string]func(context.Context, any, T) (any, error),
 	defaultFn func(string, any, T) (any, error),
 	name string, arg T) (*call[T], error) {
+	if ctxFn, has := ctxFnMap[name]; has {
+		return &call[T]{callee: callee[T]{name: name, ctxFn: ctxFn}, arg: arg}, nil
+	}
 	fn, has := fnMap[name]
 	if !has {
 		if defaultFn != nil {
@@ -124,44 +206,271 @@ func newCall[T TArg](
 			return nil, ErrNoSuchMethod
 		}
 	}
-	return &call[T]{
-		name: name,
-		arg:  arg,
-		fn:   fn,
-	}, nil
+	return &call[T]{callee: callee[T]{name: name, fn: fn}, arg: arg}, nil
 }
 
-func (c *call[T]) Eval(ctx *Context) (err error) {
-	ctx.result, err = c.fn(ctx.Env, c.arg)
-	return
+func (c *call[T]) Eval(ctx *Context) (any, error) {
+	arg := c.arg
+	if c.argCall != nil {
+		v, err := c.argCall.Eval(ctx)
+		if err != nil {
+			return nil, err
+		} else if arg, err = c.argOf(ctx, v); err != nil {
+			return nil, err
+		}
+	}
+	return c.invoke(ctx, arg)
+}
+
+// argOf converts the result of argCall to the argument.
+func (c *call[T]) argOf(ctx *Context, v any) (T, error) {
+	var zero T
+	if isNull(v) {
+		return zero, ctx.nullError()
+	} else if arg, is := convertArg[T](v); is {
+		return arg, nil
+	}
+	return zero, ErrTypeNotMatched
 }
 
 func (c *call[T]) IsTrue(ctx *Context) (bool, error) {
-	if err := c.Eval(ctx); err != nil {
+	return callIsTrue(ctx, c, c.not)
+}
+
+// callIsTrue tells if the result of a call used as a condition is truthy.
+func callIsTrue(ctx *Context, c EvalAst, not bool) (bool, error) {
+	if v, err := c.Eval(ctx); err != nil {
 		return false, err
+	} else {
+		return truthOf(ctx, v, not)
 	}
-	switch result := ctx.result.(type) {
-	case int:
-		return (result != 0) != c.not, nil
-	case string:
-		return (result != "") != c.not, nil
-	case bool:
-		return result != c.not, nil
-	default:
-		return reflect.ValueOf(result).IsZero() == c.not, nil
+}
+
+func truthOf(ctx *Context, v any, not bool) (bool, error) {
+	if ctx.ThreeValued && isNull(v) {
+		return false, ErrUnknown
 	}
+	return isTruthy(v) != not, nil
 }
 
 func (c *call[T]) Not() BoolAst {
 	return &call[T]{
-		name: c.name,
-		arg:  c.arg,
-		fn:   c.fn,
-		not:  !c.not,
+		callee:  c.callee,
+		arg:     c.arg,
+		argCall: c.argCall,
+		not:     !c.not,
 	}
 }
 
-func compareByOp[T TArg](a, b T, op int) bool {
+// funcCall is a call to a Method. If all the arguments are literals, their
+// values are kept in consts and passed to fn as is.
+type funcCall struct {
+	callee[[]any]
+	args   []EvalAst
+	params []Type
+	consts []any
+	not    bool
+}
+
+func newFuncCall(name string, method Method, args []EvalAst) *funcCall {
+	c := &funcCall{
+		callee: callee[[]any]{
+			name:     name,
+			fn:       method.Fn,
+			ctxFn:    method.CtxFn,
+			volatile: method.NonDeterministic,
+		},
+		params: make([]Type, len(args)),
+	}
+	for i := range args {
+		c.params[i] = method.paramType(i)
+	}
+	c.setArgs(args)
+	return c
+}
+
+// setArgs sets the arguments, their values are kept in consts if they are all
+// literals of the param types.
+func (c *funcCall) setArgs(args []EvalAst) {
+	c.args = args
+	consts := make([]any, len(args))
+	for i, arg := range args {
+		lit, is := arg.(*Literal)
+		if !is {
+			consts = nil
+			break
+		}
+		v, err := convertTo(lit.Value, c.params[i])
+		if err != nil {
+			consts = nil
+			break
+		}
+		consts[i] = v
+	}
+	c.consts = consts
+}
+
+func (c *funcCall) Eval(ctx *Context) (any, error) {
+	args := c.consts
+	if args == nil {
+		args = make([]any, len(c.args))
+		for i, arg := range c.args {
+			if v, err := arg.Eval(ctx); err != nil {
+				return nil, err
+			} else if args[i], err = c.argOf(ctx, i, v); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return c.invoke(ctx, args)
+}
+
+// argOf converts the result of the i-th argument to the param type.
+func (c *funcCall) argOf(ctx *Context, i int, v any) (any, error) {
+	if isNull(v) {
+		return nil, ctx.nullError()
+	}
+	return convertTo(v, c.params[i])
+}
+
+func (c *funcCall) IsTrue(ctx *Context) (bool, error) {
+	return callIsTrue(ctx, c, c.not)
+}
+
+func (c *funcCall) Not() BoolAst {
+	return &funcCall{
+		callee: c.callee,
+		args:   c.args,
+		params: c.params,
+		consts: c.consts,
+		not:    !c.not,
+	}
+}
+
+// Literal is a constant value, currently true and false in conditions and
+// comparisons.
+type Literal struct {
+	Value any
+}
+
+func (l *Literal) Eval(ctx *Context) (any, error) {
+	return l.Value, nil
+}
+
+func (l *Literal) IsTrue(ctx *Context) (bool, error) {
+	return isTruthy(l.Value), nil
+}
+
+func (l *Literal) Not() BoolAst {
+	return &Literal{Value: !isTruthy(l.Value)}
+}
+
+// Arith is an arithmetic operation on the results of two calls, literals or
+// other operations. A null operand makes the result null.
+type Arith struct {
+	Left, Right Call
+	Op          int
+	not         bool
+}
+
+func (a *Arith) Eval(ctx *Context) (any, error) {
+	left, right, err := evalBoth(ctx, a.Left, a.Right)
+	if err != nil {
+		return nil, err
+	}
+	return a.apply(left, right)
+}
+
+func (a *Arith) apply(left, right any) (any, error) {
+	if isNull(left) || isNull(right) {
+		return nil, nil
+	}
+	return arith(left, right, a.Op)
+}
+
+func (a *Arith) IsTrue(ctx *Context) (bool, error) {
+	return callIsTrue(ctx, a, a.not)
+}
+
+// evalBoth evaluates the two operands of a binary node
+func evalBoth(ctx *Context, left, right EvalAst) (any, any, error) {
+	v1, err := left.Eval(ctx)
+	if err != nil {
+		return nil, nil, err
+	}
+	v2, err := right.Eval(ctx)
+	if err != nil {
+		return nil, nil, err
+	}
+	return v1, v2, nil
+}
+
+func (a *Arith) Not() BoolAst {
+	return &Arith{
+		Left:  a.Left,
+		Right: a.Right,
+		Op:    a.Op,
+		not:   !a.not,
+	}
+}
+
+// Field is a field of the env, or a path like user.tags[0] into it. The first
+// name is bound by ParseConfig.FieldResolver if there is one, the rest of the
+// path is looked up in the value of it. Without a resolver, a Document env
+// looks up the whole path itself. A missing field or index is null.
+type Field struct {
+	Path []PathSegment
+	get  func(any) (any, error)
+	// whole is set if get reads the whole path, like in a compiled filter
+	whole bool
+	not   bool
+}
+
+func (f *Field) Eval(ctx *Context) (any, error) {
+	v, path := ctx.Env, f.Path
+	if f.get != nil {
+		var err error
+		if v, err = f.get(v); err != nil || f.whole {
+			return v, err
+		}
+		path = path[1:]
+	} else if doc, is := v.(Document); is {
+		v, _ = doc.Lookup(path)
+		return v, nil
+	}
+	v, _ = lookupPath(v, path)
+	return v, nil
+}
+
+func (f *Field) IsTrue(ctx *Context) (bool, error) {
+	return callIsTrue(ctx, f, f.not)
+}
+
+func (f *Field) Not() BoolAst {
+	return &Field{Path: f.Path, get: f.get, whole: f.whole, not: !f.not}
+}
+
+type IsNull struct {
+	Call    Call
+	NotNull bool
+}
+
+func (c *IsNull) IsTrue(ctx *Context) (bool, error) {
+	if v, err := c.Call.Eval(ctx); err != nil {
+		return false, err
+	} else {
+		return isNull(v) != c.NotNull, nil
+	}
+}
+
+func (c *IsNull) Not() BoolAst {
+	return &IsNull{
+		Call:    c.Call,
+		NotNull: !c.NotNull,
+	}
+}
+
+func compareByOp[T ordered](a, b T, op int) bool {
 	switch op {
 	case TOKEN_OP_EQ:
 		return a == b
@@ -194,6 +503,10 @@ func reverseOp(op int) int {
 		return TOKEN_OP_LE
 	case TOKEN_OP_GE:
 		return TOKEN_OP_LT
+	case TOKEN_OP_MATCH:
+		return TOKEN_OP_NOT_MATCH
+	case TOKEN_OP_NOT_MATCH:
+		return TOKEN_OP_MATCH
 	}
 	panic("invalid compare op")
 }
@@ -205,14 +518,21 @@ type Compare[T TArg] struct {
 }
 
 func (c *Compare[T]) IsTrue(ctx *Context) (bool, error) {
-	if err := c.Call.Eval(ctx); err != nil {
+	v, err := c.Call.Eval(ctx)
+	if err != nil {
 		return false, err
 	}
-	if result, is := ctx.result.(T); !is {
-		return false, ErrTypeNotMatched
-	} else {
+	return c.test(ctx, v)
+}
+
+// test compares the result of Call with Target.
+func (c *Compare[T]) test(ctx *Context, v any) (bool, error) {
+	if result, is := v.(T); is {
 		return compareByOp(result, c.Target, c.Op), nil
+	} else if isNull(v) {
+		return false, ctx.nullError()
 	}
+	return compareValues(v, c.Target, c.Op)
 }
 
 func (c *Compare[T]) Not() BoolAst {
@@ -223,6 +543,115 @@ func (c *Compare[T]) Not() BoolAst {
 	}
 }
 
+// StrMatch is one of the string pattern operators LIKE, ILIKE, GLOB,
+// STARTS WITH, ENDS WITH, CONTAINS and ~. For ILIKE the pattern is kept lower
+// cased, for ~ it's compiled to a regexp when the query is parsed.
+type StrMatch struct {
+	Call     Call
+	Op       int
+	Pattern  string
+	NotMatch bool
+	re       *regexp.Regexp
+}
+
+func newStrMatch(c Call, op int, pattern string, not bool) (*StrMatch, error) {
+	m := &StrMatch{Call: c, Op: op, Pattern: pattern, NotMatch: not}
+	switch op {
+	case TOKEN_OP_ILIKE:
+		m.Pattern = strings.ToLower(pattern)
+	case TOKEN_OP_MATCH:
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			return nil, err
+		}
+		m.re = re
+	}
+	return m, nil
+}
+
+func (c *StrMatch) IsTrue(ctx *Context) (bool, error) {
+	if v, err := c.Call.Eval(ctx); err != nil {
+		return false, err
+	} else {
+		return c.test(ctx, v)
+	}
+}
+
+// test matches the result of Call with Pattern.
+func (c *StrMatch) test(ctx *Context, v any) (bool, error) {
+	if isNull(v) {
+		return false, ctx.nullError()
+	} else if s, is := v.(string); !is {
+		return false, ErrTypeNotMatched
+	} else if c.re != nil {
+		return c.re.MatchString(s) != c.NotMatch, nil
+	} else {
+		return matchStr(s, c.Pattern, c.Op) != c.NotMatch, nil
+	}
+}
+
+func (c *StrMatch) Not() BoolAst {
+	return &StrMatch{
+		Call:     c.Call,
+		Op:       c.Op,
+		Pattern:  c.Pattern,
+		NotMatch: !c.NotMatch,
+		re:       c.re,
+	}
+}
+
+// Between checks that the result of Call is within Lower and Upper. The
+// bounds are included unless Exclusive is set.
+type Between struct {
+	Call         Call
+	Lower, Upper Call
+	Exclusive    bool
+	NotBetween   bool
+}
+
+func (c *Between) IsTrue(ctx *Context) (bool, error) {
+	v, err := c.Call.Eval(ctx)
+	if err != nil {
+		return false, err
+	}
+	lower, upper, err := evalBoth(ctx, c.Lower, c.Upper)
+	if err != nil {
+		return false, err
+	}
+	return c.test(ctx, v, lower, upper)
+}
+
+// test checks the result of Call with the results of the bounds.
+func (c *Between) test(ctx *Context, v, lower, upper any) (bool, error) {
+	if isNull(v) || isNull(lower) || isNull(upper) {
+		return false, ctx.nullError()
+	}
+	lowerOp, upperOp := TOKEN_OP_GE, TOKEN_OP_LE
+	if c.Exclusive {
+		lowerOp, upperOp = TOKEN_OP_GT, TOKEN_OP_LT
+	}
+	if rv, err := compareValues(v, lower, lowerOp); err != nil {
+		return false, err
+	} else if !rv {
+		return c.NotBetween, nil
+	}
+	if rv, err := compareValues(v, upper, upperOp); err != nil {
+		return false, err
+	} else {
+		return rv != c.NotBetween, nil
+	}
+}
+
+func (c *Between) Not() BoolAst {
+	return &Between{
+		Call:       c.Call,
+		Lower:      c.Lower,
+		Upper:      c.Upper,
+		Exclusive:  c.Exclusive,
+		NotBetween: !c.NotBetween,
+	}
+}
+
 func inSlice[T TArg](val T, slice []T) bool {
 	for _, item := range slice {
 		if val == item {
@@ -239,13 +668,21 @@ type In[T TArg] struct {
 }
 
 func (c *In[T]) IsTrue(ctx *Context) (bool, error) {
-	if err := c.Call.Eval(ctx); err != nil {
+	v, err := c.Call.Eval(ctx)
+	if err != nil {
 		return false, err
 	}
-	if result, is := ctx.result.(T); !is {
-		return false, ErrTypeNotMatched
+	return c.test(ctx, v)
+}
+
+// test looks for the result of Call in Choices.
+func (c *In[T]) test(ctx *Context, v any) (bool, error) {
+	if isNull(v) {
+		return false, ctx.nullError()
+	} else if in, err := inValues(v, c.Choices); err != nil {
+		return false, err
 	} else {
-		return inSlice(result, c.Choices) != c.NotIn, nil
+		return in != c.NotIn, nil
 	}
 }
 
@@ -263,25 +700,21 @@ type CompareWithCall struct {
 }
 
 func (c *CompareWithCall) IsTrue(ctx *Context) (bool, error) {
-	if err := c.Left.Eval(ctx); err != nil {
-		return false, err
-	}
-	res1 := ctx.result
-	if err := c.Right.Eval(ctx); err != nil {
+	res1, res2, err := evalBoth(ctx, c.Left, c.Right)
+	if err != nil {
 		return false, err
 	}
-	res2 := ctx.result
-	switch v1 := res1.(type) {
-	case int:
-		if v2, is := res2.(int); is {
-			return compareByOp(v1, v2, c.Op), nil
-		}
-	case string:
-		if v2, is := res2.(string); is {
-			return compareByOp(v1, v2, c.Op), nil
-		}
+	return c.test(ctx, res1, res2)
+}
+
+// test compares the results of Left and Right.
+func (c *CompareWithCall) test(ctx *Context, res1, res2 any) (bool, error) {
+	if isNull(res1) || isNull(res2) {
+		return false, ctx.nullError()
+	} else if c.Op == TOKEN_OP_MATCH || c.Op == TOKEN_OP_NOT_MATCH {
+		return matchRegexp(res1, res2, c.Op == TOKEN_OP_NOT_MATCH)
 	}
-	return false, ErrTypeNotMatched
+	return compareValues(res1, res2, c.Op)
 }
 
 func (c *CompareWithCall) Not() BoolAst {
@@ -298,25 +731,22 @@ type InWithCall struct {
 }
 
 func (c *InWithCall) IsTrue(ctx *Context) (bool, error) {
-	if err := c.Left.Eval(ctx); err != nil {
+	res1, res2, err := evalBoth(ctx, c.Left, c.Right)
+	if err != nil {
 		return false, err
 	}
-	res1 := ctx.result
-	if err := c.Right.Eval(ctx); err != nil {
+	return c.test(ctx, res1, res2)
+}
+
+// test looks for the result of Left in the list Right results in.
+func (c *InWithCall) test(ctx *Context, res1, res2 any) (bool, error) {
+	if isNull(res1) || isNull(res2) {
+		return false, ctx.nullError()
+	} else if in, err := inList(res1, res2); err != nil {
 		return false, err
+	} else {
+		return in != c.NotIn, nil
 	}
-	res2 := ctx.result
-	switch v1 := res1.(type) {
-	case int:
-		if v2, is := res2.([]int); is {
-			return inSlice(v1, v2) != c.NotIn, nil
-		}
-	case string:
-		if v2, is := res2.([]string); is {
-			return inSlice(v1, v2) != c.NotIn, nil
-		}
-	}
-	return false, ErrTypeNotMatched
 }
 
 func (c *InWithCall) Not() BoolAst {
@@ -328,9 +758,8 @@ func (c *InWithCall) Not() BoolAst {
 }
 
 type callThenCompare[T1, T2 TArg] struct {
-	name   string
+	callee[T1]
 	arg    T1
-	fn     func(any, T1) (any, error)
 	target T2
 	op     int
 }
@@ -338,50 +767,70 @@ type callThenCompare[T1, T2 TArg] struct {
 func newCallThenCompare[T TArg](ci Call, op int, target T) BoolAst {
 	switch c := ci.(type) {
 	case *call[int]:
+		if c.argCall != nil {
+			break
+		}
 		return &callThenCompare[int, T]{
-			name:   c.name,
+			callee: c.callee,
+			arg:    c.arg,
+			target: target,
+			op:     op,
+		}
+	case *call[float64]:
+		if c.argCall != nil {
+			break
+		}
+		return &callThenCompare[float64, T]{
+			callee: c.callee,
 			arg:    c.arg,
-			fn:     c.fn,
 			target: target,
 			op:     op,
 		}
 	case *call[string]:
+		if c.argCall != nil {
+			break
+		}
 		return &callThenCompare[string, T]{
-			name:   c.name,
+			callee: c.callee,
 			arg:    c.arg,
-			fn:     c.fn,
 			target: target,
 			op:     op,
 		}
 	}
-	panic("invalid call")
+	return &Compare[T]{Call: ci, Op: op, Target: target}
 }
 
 func (c *callThenCompare[T1, T2]) IsTrue(ctx *Context) (bool, error) {
-	ret, err := c.fn(ctx.Env, c.arg)
+	ret, err := c.invoke(ctx, c.arg)
 	if err != nil {
 		return false, err
-	} else if result, is := ret.(T2); !is {
-		return false, ErrTypeNotMatched
-	} else {
+	}
+	return c.test(ctx, ret)
+}
+
+// test compares the result of the call with target.
+func (c *callThenCompare[T1, T2]) test(ctx *Context, ret any) (bool, error) {
+	if result, is := ret.(T2); is {
 		return compareByOp(result, c.target, c.op), nil
+	} else if isNull(ret) {
+		return false, ctx.nullError()
+	} else {
+		return compareValues(ret, c.target, c.op)
 	}
 }
 
 func (c *callThenCompare[T1, T2]) Not() BoolAst {
 	return &callThenCompare[T1, T2]{
-		name:   c.name,
+		callee: c.callee,
 		arg:    c.arg,
-		fn:     c.fn,
 		target: c.target,
 		op:     reverseOp(c.op),
 	}
 }
 
 type callThenIn[T1, T2 TArg] struct {
-	name    string
+	callee[T1]
 	arg     T1
-	fn      func(any, T1) (any, error)
 	choices []T2
 	not     bool
 }
@@ -389,40 +838,62 @@ type callThenIn[T1, T2 TArg] struct {
 func newCallThenIn[T TArg](ci Call, choices []T, not bool) BoolAst {
 	switch c := ci.(type) {
 	case *call[int]:
+		if c.argCall != nil {
+			break
+		}
 		return &callThenIn[int, T]{
-			name:    c.name,
+			callee:  c.callee,
+			arg:     c.arg,
+			choices: choices,
+			not:     not,
+		}
+	case *call[float64]:
+		if c.argCall != nil {
+			break
+		}
+		return &callThenIn[float64, T]{
+			callee:  c.callee,
 			arg:     c.arg,
-			fn:      c.fn,
 			choices: choices,
 			not:     not,
 		}
 	case *call[string]:
+		if c.argCall != nil {
+			break
+		}
 		return &callThenIn[string, T]{
-			name:    c.name,
+			callee:  c.callee,
 			arg:     c.arg,
-			fn:      c.fn,
 			choices: choices,
+			not:     not,
 		}
 	}
-	panic("invalid call")
+	return &In[T]{Call: ci, Choices: choices, NotIn: not}
 }
 
 func (c *callThenIn[T1, T2]) IsTrue(ctx *Context) (bool, error) {
-	ret, err := c.fn(ctx.Env, c.arg)
+	ret, err := c.invoke(ctx, c.arg)
 	if err != nil {
 		return false, err
-	} else if result, is := ret.(T2); !is {
-		return false, ErrTypeNotMatched
+	}
+	return c.test(ctx, ret)
+}
+
+// test looks for the result of the call in choices.
+func (c *callThenIn[T1, T2]) test(ctx *Context, ret any) (bool, error) {
+	if isNull(ret) {
+		return false, ctx.nullError()
+	} else if in, err := inValues(ret, c.choices); err != nil {
+		return false, err
 	} else {
-		return inSlice(result, c.choices) != c.not, nil
+		return in != c.not, nil
 	}
 }
 
 func (c *callThenIn[T1, T2]) Not() BoolAst {
 	return &callThenIn[T1, T2]{
-		name:    c.name,
+		callee:  c.callee,
 		arg:     c.arg,
-		fn:      c.fn,
 		choices: c.choices,
 		not:     !c.not,
 	}
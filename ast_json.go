@@ -0,0 +1,496 @@
+package filterql
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+// FilterJSONVersion is the version of the JSON schema written by
+// MarshalFilter. Every node is an object with a "type":
+//
+//	and, or      children
+//	not          child
+//	compare      op (= <> > >= < <= ~ !~), left, right
+//	in           left, and choices (literals) or right, not
+//	match        op (like ilike glob "starts with" "ends with" contains ~),
+//	             left, pattern, not
+//	between      left, lower, upper, exclusive, not
+//	is_null      left, not
+//	call         name, args, not
+//	arith        op (+ - * / %), left, right, not
+//	field        path ([{"name": "a"}, {"index": 0}]), not
+//	literal      kind (int float str bool null), value
+const FilterJSONVersion = 1
+
+type jsonFilter struct {
+	Version int       `json:"version"`
+	Filter  *jsonNode `json:"filter"`
+}
+
+type jsonNode struct {
+	Type      string          `json:"type"`
+	Name      string          `json:"name,omitempty"`
+	Op        string          `json:"op,omitempty"`
+	Kind      string          `json:"kind,omitempty"`
+	Value     json.RawMessage `json:"value,omitempty"`
+	Path      []jsonSegment   `json:"path,omitempty"`
+	Pattern   *string         `json:"pattern,omitempty"`
+	Args      []*jsonNode     `json:"args,omitempty"`
+	Children  []*jsonNode     `json:"children,omitempty"`
+	Child     *jsonNode       `json:"child,omitempty"`
+	Left      *jsonNode       `json:"left,omitempty"`
+	Right     *jsonNode       `json:"right,omitempty"`
+	Lower     *jsonNode       `json:"lower,omitempty"`
+	Upper     *jsonNode       `json:"upper,omitempty"`
+	Choices   []*jsonNode     `json:"choices,omitempty"`
+	Exclusive bool            `json:"exclusive,omitempty"`
+	Not       bool            `json:"not,omitempty"`
+}
+
+type jsonSegment struct {
+	Name  string `json:"name,omitempty"`
+	Index *int   `json:"index,omitempty"`
+}
+
+// jsonable is implemented by all the nodes of this package.
+type jsonable interface {
+	toJSON() *jsonNode
+}
+
+// nodeJSON returns the JSON node of n, a node from another package gets a
+// type which UnmarshalFilter rejects.
+func nodeJSON(n PrintableAst) *jsonNode {
+	if j, is := n.(jsonable); is {
+		return j.toJSON()
+	}
+	return &jsonNode{Type: fmt.Sprintf("%T", n)}
+}
+
+func nodesJSON[T PrintableAst](nodes []T) []*jsonNode {
+	list := make([]*jsonNode, len(nodes))
+	for i, n := range nodes {
+		list[i] = nodeJSON(n)
+	}
+	return list
+}
+
+func literalJSON(v any) *jsonNode {
+	n := &jsonNode{Type: "literal"}
+	switch v.(type) {
+	case int, uint64:
+		n.Kind = "int"
+		n.Value = json.RawMessage(literalString(v))
+	case float64:
+		n.Kind = "float"
+		n.Value = json.RawMessage(literalString(v))
+	case string:
+		n.Kind = "str"
+		n.Value, _ = json.Marshal(v)
+	case bool:
+		n.Kind = "bool"
+		n.Value = json.RawMessage(literalString(v))
+	case nil:
+		n.Kind = "null"
+	default:
+		n.Kind = fmt.Sprintf("%T", v)
+	}
+	return n
+}
+
+func literalsJSON[T TArg](values []T) []*jsonNode {
+	list := make([]*jsonNode, len(values))
+	for i, v := range values {
+		list[i] = literalJSON(v)
+	}
+	return list
+}
+
+func (a *ANDs) toJSON() *jsonNode {
+	return &jsonNode{Type: "and", Children: nodesJSON(a.Children)}
+}
+
+func (a *ORs) toJSON() *jsonNode {
+	return &jsonNode{Type: "or", Children: nodesJSON(a.Children)}
+}
+
+func (a *NOT) toJSON() *jsonNode {
+	return &jsonNode{Type: "not", Child: nodeJSON(a.Child)}
+}
+
+func (c *call[T]) toJSON() *jsonNode {
+	arg := literalJSON(c.arg)
+	if c.argCall != nil {
+		arg = nodeJSON(c.argCall)
+	}
+	return &jsonNode{Type: "call", Name: c.name, Args: []*jsonNode{arg}, Not: c.not}
+}
+
+func (c *funcCall) toJSON() *jsonNode {
+	return &jsonNode{Type: "call", Name: c.name, Args: nodesJSON(c.args), Not: c.not}
+}
+
+func (l *Literal) toJSON() *jsonNode {
+	return literalJSON(l.Value)
+}
+
+func (a *Arith) toJSON() *jsonNode {
+	return &jsonNode{Type: "arith", Op: opString(a.Op), Left: nodeJSON(a.Left), Right: nodeJSON(a.Right), Not: a.not}
+}
+
+func (f *Field) toJSON() *jsonNode {
+	path := make([]jsonSegment, len(f.Path))
+	for i, seg := range f.Path {
+		if seg.IsIndex {
+			index := seg.Index
+			path[i].Index = &index
+		} else {
+			path[i].Name = seg.Name
+		}
+	}
+	return &jsonNode{Type: "field", Path: path, Not: f.not}
+}
+
+func (c *IsNull) toJSON() *jsonNode {
+	return &jsonNode{Type: "is_null", Left: nodeJSON(c.Call), Not: c.NotNull}
+}
+
+func (c *Compare[T]) toJSON() *jsonNode {
+	return &jsonNode{Type: "compare", Op: opString(c.Op), Left: nodeJSON(c.Call), Right: literalJSON(c.Target)}
+}
+
+func (c *StrMatch) toJSON() *jsonNode {
+	pattern := c.Pattern
+	return &jsonNode{Type: "match", Op: opString(c.Op), Left: nodeJSON(c.Call), Pattern: &pattern, Not: c.NotMatch}
+}
+
+func (c *Between) toJSON() *jsonNode {
+	return &jsonNode{Type: "between", Left: nodeJSON(c.Call), Lower: nodeJSON(c.Lower), Upper: nodeJSON(c.Upper),
+		Exclusive: c.Exclusive, Not: c.NotBetween}
+}
+
+func (c *In[T]) toJSON() *jsonNode {
+	return &jsonNode{Type: "in", Left: nodeJSON(c.Call), Choices: literalsJSON(c.Choices), Not: c.NotIn}
+}
+
+func (c *CompareWithCall) toJSON() *jsonNode {
+	return &jsonNode{Type: "compare", Op: opString(c.Op), Left: nodeJSON(c.Left), Right: nodeJSON(c.Right)}
+}
+
+func (c *InWithCall) toJSON() *jsonNode {
+	return &jsonNode{Type: "in", Left: nodeJSON(c.Left), Right: nodeJSON(c.Right), Not: c.NotIn}
+}
+
+func (c *callThenCompare[T1, T2]) toJSON() *jsonNode {
+	left := &jsonNode{Type: "call", Name: c.name, Args: []*jsonNode{literalJSON(c.arg)}}
+	return &jsonNode{Type: "compare", Op: opString(c.op), Left: left, Right: literalJSON(c.target)}
+}
+
+func (c *callThenIn[T1, T2]) toJSON() *jsonNode {
+	left := &jsonNode{Type: "call", Name: c.name, Args: []*jsonNode{literalJSON(c.arg)}}
+	return &jsonNode{Type: "in", Left: left, Choices: literalsJSON(c.choices), Not: c.not}
+}
+
+func (a *ANDs) MarshalJSON() ([]byte, error)                    { return json.Marshal(a.toJSON()) }
+func (a *ORs) MarshalJSON() ([]byte, error)                     { return json.Marshal(a.toJSON()) }
+func (a *NOT) MarshalJSON() ([]byte, error)                     { return json.Marshal(a.toJSON()) }
+func (c *call[T]) MarshalJSON() ([]byte, error)                 { return json.Marshal(c.toJSON()) }
+func (c *funcCall) MarshalJSON() ([]byte, error)                { return json.Marshal(c.toJSON()) }
+func (l *Literal) MarshalJSON() ([]byte, error)                 { return json.Marshal(l.toJSON()) }
+func (a *Arith) MarshalJSON() ([]byte, error)                   { return json.Marshal(a.toJSON()) }
+func (f *Field) MarshalJSON() ([]byte, error)                   { return json.Marshal(f.toJSON()) }
+func (c *IsNull) MarshalJSON() ([]byte, error)                  { return json.Marshal(c.toJSON()) }
+func (c *Compare[T]) MarshalJSON() ([]byte, error)              { return json.Marshal(c.toJSON()) }
+func (c *StrMatch) MarshalJSON() ([]byte, error)                { return json.Marshal(c.toJSON()) }
+func (c *Between) MarshalJSON() ([]byte, error)                 { return json.Marshal(c.toJSON()) }
+func (c *In[T]) MarshalJSON() ([]byte, error)                   { return json.Marshal(c.toJSON()) }
+func (c *CompareWithCall) MarshalJSON() ([]byte, error)         { return json.Marshal(c.toJSON()) }
+func (c *InWithCall) MarshalJSON() ([]byte, error)              { return json.Marshal(c.toJSON()) }
+func (c *callThenCompare[T1, T2]) MarshalJSON() ([]byte, error) { return json.Marshal(c.toJSON()) }
+func (c *callThenIn[T1, T2]) MarshalJSON() ([]byte, error)      { return json.Marshal(c.toJSON()) }
+
+// MarshalFilter returns the JSON of cond in a versioned envelope:
+// {"version": FilterJSONVersion, "filter": node}. NaN and the infinities have
+// no JSON, they fail with ErrInvalidFilter.
+func MarshalFilter(cond BoolAst) ([]byte, error) {
+	data, err := json.Marshal(jsonFilter{Version: FilterJSONVersion, Filter: nodeJSON(cond)})
+	if err != nil {
+		return nil, fmt.Errorf("%v: %w", err, ErrInvalidFilter)
+	}
+	return data, nil
+}
+
+// UnmarshalFilter rebuilds a filter from the JSON written by MarshalFilter, or
+// by MarshalJSON of a node. The calls and fields are bound to cfg as if the
+// filter were parsed, so an unknown method fails with ErrNoSuchMethod and
+// the type checks of Parse apply.
+func UnmarshalFilter(data []byte, cfg *ParseConfig) (BoolAst, error) {
+	if cfg == nil {
+		cfg = &defaultConfig
+	}
+	var envelope jsonFilter
+	if err := json.Unmarshal(data, &envelope); err != nil {
+		return nil, err
+	}
+	root := envelope.Filter
+	if root == nil && envelope.Version == 0 {
+		if err := json.Unmarshal(data, &root); err != nil {
+			return nil, err
+		}
+	} else if envelope.Version != FilterJSONVersion {
+		return nil, fmt.Errorf("filter version %d: %w", envelope.Version, ErrUnsupportedVersion)
+	}
+	return (&jsonDecoder{cfg: cfg}).cond(root)
+}
+
+type jsonDecoder struct {
+	cfg *ParseConfig
+}
+
+// fail returns the error of building node n.
+func (d *jsonDecoder) fail(n *jsonNode, err error) error {
+	err = bareError(err)
+	if n.Name != "" {
+		return fmt.Errorf("%s node %s: %w", n.Type, n.Name, err)
+	}
+	return fmt.Errorf("%s node: %w", n.Type, err)
+}
+
+func (d *jsonDecoder) cond(n *jsonNode) (BoolAst, error) {
+	if n == nil {
+		return nil, fmt.Errorf("missing node: %w", ErrInvalidFilter)
+	}
+	switch n.Type {
+	case "and", "or":
+		if len(n.Children) == 0 {
+			return nil, d.fail(n, ErrInvalidFilter)
+		}
+		children := make([]BoolAst, len(n.Children))
+		for i, child := range n.Children {
+			var err error
+			if children[i], err = d.cond(child); err != nil {
+				return nil, err
+			}
+		}
+		if n.Type == "and" {
+			return &ANDs{Children: children}, nil
+		}
+		return &ORs{Children: children}, nil
+	case "not":
+		child, err := d.cond(n.Child)
+		if err != nil {
+			return nil, err
+		}
+		return &NOT{Child: child}, nil
+	case "compare":
+		left, right, err := d.exprs(n.Left, n.Right)
+		if err != nil {
+			return nil, err
+		}
+		var cond BoolAst
+		switch op := jsonOps[n.Op]; {
+		case inSlice(op, compareOps):
+			cond, err = newCompare(d.cfg, left, op, right, 0)
+		case op == TOKEN_OP_MATCH || op == TOKEN_OP_NOT_MATCH:
+			cond, err = newRegexpMatch(d.cfg, left, op, right, 0)
+		default:
+			err = ErrInvalidFilter
+		}
+		if err != nil {
+			return nil, d.fail(n, err)
+		}
+		return cond, nil
+	case "in":
+		left, err := d.expr(n.Left)
+		if err != nil {
+			return nil, err
+		}
+		var cond BoolAst
+		if n.Right != nil {
+			right, err := d.expr(n.Right)
+			if err != nil {
+				return nil, err
+			}
+			cond, err = newInWithCall(d.cfg, left, right, n.Not, 0)
+		} else if len(n.Choices) == 0 {
+			err = ErrInvalidFilter
+		} else {
+			choices := make([]TokenInfo, len(n.Choices))
+			for i, choice := range n.Choices {
+				if choices[i], err = literalToken(choice); err != nil {
+					return nil, d.fail(n, err)
+				}
+			}
+			cond, err = newChoices(d.cfg, left, choices, n.Not)
+		}
+		if err != nil {
+			return nil, d.fail(n, err)
+		}
+		return cond, nil
+	case "match":
+		left, err := d.expr(n.Left)
+		if err != nil {
+			return nil, err
+		}
+		op := jsonOps[n.Op]
+		if n.Pattern == nil || !inSlice(op, strMatchOps) {
+			return nil, d.fail(n, ErrInvalidFilter)
+		}
+		cond, err := newCheckedStrMatch(d.cfg, left, op, *n.Pattern, n.Not, 0, 0)
+		if err != nil {
+			return nil, d.fail(n, err)
+		}
+		return cond, nil
+	case "between":
+		left, err := d.expr(n.Left)
+		if err != nil {
+			return nil, err
+		}
+		lower, upper, err := d.exprs(n.Lower, n.Upper)
+		if err != nil {
+			return nil, err
+		}
+		cond, err := newBetween(d.cfg, left, lower, upper, n.Exclusive, n.Not, 0, 0)
+		if err != nil {
+			return nil, d.fail(n, err)
+		}
+		return cond, nil
+	case "is_null":
+		left, err := d.expr(n.Left)
+		if err != nil {
+			return nil, err
+		}
+		return &IsNull{Call: left, NotNull: n.Not}, nil
+	}
+	return d.expr(n)
+}
+
+func (d *jsonDecoder) exprs(n1, n2 *jsonNode) (Call, Call, error) {
+	c1, err := d.expr(n1)
+	if err != nil {
+		return nil, nil, err
+	}
+	c2, err := d.expr(n2)
+	if err != nil {
+		return nil, nil, err
+	}
+	return c1, c2, nil
+}
+
+func (d *jsonDecoder) expr(n *jsonNode) (Call, error) {
+	if n == nil {
+		return nil, fmt.Errorf("missing node: %w", ErrInvalidFilter)
+	}
+	var (
+		c   Call
+		err error
+	)
+	switch n.Type {
+	case "literal":
+		var tok TokenInfo
+		var v any
+		if tok, err = literalToken(n); err == nil {
+			if v, err = literalOf(tok, TypeAny); err == nil {
+				return &Literal{Value: v}, nil
+			}
+		}
+	case "call":
+		args := make([]callArg, len(n.Args))
+		for i, arg := range n.Args {
+			if arg != nil && arg.Type == "literal" {
+				args[i].tok, err = literalToken(arg)
+			} else {
+				args[i].call, err = d.expr(arg)
+			}
+			if err != nil {
+				return nil, err
+			}
+		}
+		c, err = bindCall(d.cfg, n.Name, 0, args)
+	case "arith":
+		left, right, err := d.exprs(n.Left, n.Right)
+		if err != nil {
+			return nil, err
+		}
+		op := jsonOps[n.Op]
+		if !inSlice(op, arithOps) {
+			return nil, d.fail(n, ErrInvalidFilter)
+		}
+		c, err = newArith(d.cfg, left, op, right, 0)
+	case "field":
+		if len(n.Path) == 0 || n.Path[0].Index != nil {
+			return nil, d.fail(n, ErrInvalidFilter)
+		}
+		path := make([]PathSegment, len(n.Path))
+		for i, seg := range n.Path {
+			if seg.Index != nil {
+				path[i] = PathSegment{Index: *seg.Index, IsIndex: true}
+			} else {
+				path[i] = PathSegment{Name: seg.Name}
+			}
+		}
+		c, err = bindField(d.cfg, path, 0)
+	default:
+		err = fmt.Errorf("unknown node type %q: %w", n.Type, ErrInvalidFilter)
+	}
+	if err != nil {
+		return nil, d.fail(n, err)
+	}
+	if n.Not {
+		return c.Not().(Call), nil
+	}
+	return c, nil
+}
+
+// literalToken returns the token of a literal node, so that it's converted
+// the same way as in a parsed query.
+func literalToken(n *jsonNode) (TokenInfo, error) {
+	if n == nil || n.Type != "literal" {
+		return TokenInfo{}, ErrInvalidFilter
+	}
+	switch n.Kind {
+	case "int", "float":
+		var num json.Number
+		if err := json.Unmarshal(n.Value, &num); err != nil {
+			return TokenInfo{}, ErrInvalidFilter
+		}
+		if n.Kind == "int" {
+			return TokenInfo{Type: TOKEN_INT, Text: []rune(num.String())}, nil
+		}
+		return TokenInfo{Type: TOKEN_FLOAT, Text: []rune(num.String())}, nil
+	case "str":
+		var s string
+		if err := json.Unmarshal(n.Value, &s); err != nil {
+			return TokenInfo{}, ErrInvalidFilter
+		}
+		return TokenInfo{Type: TOKEN_STR, Text: []rune(quoteStr(s))}, nil
+	case "bool":
+		var b bool
+		if err := json.Unmarshal(n.Value, &b); err != nil {
+			return TokenInfo{}, ErrInvalidFilter
+		} else if b {
+			return TokenInfo{Type: TOKEN_TRUE, Text: []rune("true")}, nil
+		}
+		return TokenInfo{Type: TOKEN_FALSE, Text: []rune("false")}, nil
+	case "null":
+		return TokenInfo{Type: TOKEN_NULL, Text: []rune("null")}, nil
+	}
+	return TokenInfo{}, ErrInvalidFilter
+}
+
+var (
+	compareOps  = []int{TOKEN_OP_EQ, TOKEN_OP_NE, TOKEN_OP_GT, TOKEN_OP_GE, TOKEN_OP_LT, TOKEN_OP_LE}
+	strMatchOps = []int{TOKEN_OP_LIKE, TOKEN_OP_ILIKE, TOKEN_OP_GLOB, TOKEN_OP_STARTS, TOKEN_OP_ENDS,
+		TOKEN_OP_CONTAINS, TOKEN_OP_MATCH}
+	arithOps = []int{TOKEN_OP_ADD, TOKEN_OP_SUB, TOKEN_OP_MUL, TOKEN_OP_DIV, TOKEN_OP_MOD}
+)
+
+// jsonOps are the operators by their names in JSON
+var jsonOps = func() map[string]int {
+	ops := map[string]int{opString(TOKEN_OP_NOT_MATCH): TOKEN_OP_NOT_MATCH}
+	for _, list := range [][]int{compareOps, strMatchOps, arithOps} {
+		for _, op := range list {
+			ops[opString(op)] = op
+		}
+	}
+	return ops
+}()
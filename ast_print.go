@@ -37,6 +37,12 @@ func (a *call[T]) PrintTo(level int, out io.Writer) {
 	if a.not {
 		prefix = "!"
 	}
+	if a.argCall != nil {
+		fmt.Fprintf(out, "%s%s%s(\n", indent, prefix, a.name)
+		a.argCall.PrintTo(level+1, out)
+		fmt.Fprintf(out, "%s)\n", indent)
+		return
+	}
 	fmt.Fprintf(out, "%s%s%s(%#v)\n", indent, prefix, a.name, a.arg)
 }
 
@@ -103,3 +109,90 @@ func (a *callThenIn[T1, T2]) PrintTo(level int, out io.Writer) {
 	}
 	fmt.Fprintf(out, "%s)\n", indent)
 }
+
+func (a *Literal) PrintTo(level int, out io.Writer) {
+	indent := strings.Repeat("  ", level)
+	fmt.Fprintf(out, "%s%#v\n", indent, a.Value)
+}
+
+func (a *Field) PrintTo(level int, out io.Writer) {
+	indent := strings.Repeat("  ", level)
+	prefix := ""
+	if a.not {
+		prefix = "!"
+	}
+	fmt.Fprintf(out, "%s%sField(%s)\n", indent, prefix, pathString(a.Path))
+}
+
+func (a *IsNull) PrintTo(level int, out io.Writer) {
+	indent := strings.Repeat("  ", level)
+	if a.NotNull {
+		fmt.Fprintf(out, "%sIsNotNull (\n", indent)
+	} else {
+		fmt.Fprintf(out, "%sIsNull (\n", indent)
+	}
+	a.Call.PrintTo(level+1, out)
+	fmt.Fprintf(out, "%s)\n", indent)
+}
+
+func (a *funcCall) PrintTo(level int, out io.Writer) {
+	indent := strings.Repeat("  ", level)
+	prefix := ""
+	if a.not {
+		prefix = "!"
+	}
+	if a.consts == nil {
+		fmt.Fprintf(out, "%s%s%s(\n", indent, prefix, a.name)
+		for _, arg := range a.args {
+			arg.PrintTo(level+1, out)
+		}
+		fmt.Fprintf(out, "%s)\n", indent)
+		return
+	}
+	args := make([]string, len(a.consts))
+	for i, arg := range a.consts {
+		args[i] = fmt.Sprintf("%#v", arg)
+	}
+	fmt.Fprintf(out, "%s%s%s(%s)\n", indent, prefix, a.name, strings.Join(args, ", "))
+}
+
+func (a *Arith) PrintTo(level int, out io.Writer) {
+	indent := strings.Repeat("  ", level)
+	prefix := ""
+	if a.not {
+		prefix = "!"
+	}
+	fmt.Fprintf(out, "%s%sArith(%s) (\n", indent, prefix, tokenName(a.Op))
+	a.Left.PrintTo(level+1, out)
+	a.Right.PrintTo(level+1, out)
+	fmt.Fprintf(out, "%s)\n", indent)
+}
+
+func (a *StrMatch) PrintTo(level int, out io.Writer) {
+	indent := strings.Repeat("  ", level)
+	if a.NotMatch {
+		fmt.Fprintf(out, "%sNotStrMatch(%s) (\n", indent, tokenName(a.Op))
+	} else {
+		fmt.Fprintf(out, "%sStrMatch(%s) (\n", indent, tokenName(a.Op))
+	}
+	a.Call.PrintTo(level+1, out)
+	fmt.Fprintf(out, "%s  %#v\n", indent, a.Pattern)
+	fmt.Fprintf(out, "%s)\n", indent)
+}
+
+func (a *Between) PrintTo(level int, out io.Writer) {
+	indent := strings.Repeat("  ", level)
+	name := "Between"
+	if a.NotBetween {
+		name = "NotBetween"
+	}
+	if a.Exclusive {
+		fmt.Fprintf(out, "%s%s(exclusive) (\n", indent, name)
+	} else {
+		fmt.Fprintf(out, "%s%s (\n", indent, name)
+	}
+	a.Call.PrintTo(level+1, out)
+	a.Lower.PrintTo(level+1, out)
+	a.Upper.PrintTo(level+1, out)
+	fmt.Fprintf(out, "%s)\n", indent)
+}
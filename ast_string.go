@@ -0,0 +1,265 @@
+package filterql
+
+import (
+	"math"
+	"strconv"
+	"strings"
+)
+
+// String() of the nodes gives the canonical source of them, which is parsed
+// back to an equivalent tree. Keywords are lower case and only the necessary
+// brackets are kept.
+
+// precedences of the conditions
+const (
+	precOr = iota + 1
+	precAnd
+	precAtom
+)
+
+func condPrec(a BoolAst) int {
+	switch a.(type) {
+	case *ORs:
+		return precOr
+	case *ANDs:
+		return precAnd
+	}
+	return precAtom
+}
+
+// condString returns the source of a, bracketed if it binds looser than prec.
+func condString(a BoolAst, prec int) string {
+	if condPrec(a) < prec {
+		return "(" + a.String() + ")"
+	}
+	return a.String()
+}
+
+// precedences of the expressions
+const (
+	precAdd = iota + 1
+	precMul
+	precPrimary
+)
+
+func exprPrec(c Call) int {
+	if a, is := c.(*Arith); is && !a.not {
+		if a.Op == TOKEN_OP_ADD || a.Op == TOKEN_OP_SUB {
+			return precAdd
+		}
+		return precMul
+	}
+	return precPrimary
+}
+
+// exprString returns the source of c, bracketed if it binds looser than prec.
+func exprString(c Call, prec int) string {
+	if exprPrec(c) < prec {
+		return "(" + c.String() + ")"
+	}
+	return c.String()
+}
+
+func opString(op int) string {
+	switch op {
+	case TOKEN_OP_EQ:
+		return "="
+	case TOKEN_OP_NE:
+		return "<>"
+	case TOKEN_OP_GT:
+		return ">"
+	case TOKEN_OP_GE:
+		return ">="
+	case TOKEN_OP_LT:
+		return "<"
+	case TOKEN_OP_LE:
+		return "<="
+	case TOKEN_OP_ADD:
+		return "+"
+	case TOKEN_OP_SUB:
+		return "-"
+	case TOKEN_OP_MUL:
+		return "*"
+	case TOKEN_OP_DIV:
+		return "/"
+	case TOKEN_OP_MOD:
+		return "%"
+	case TOKEN_OP_LIKE:
+		return "like"
+	case TOKEN_OP_ILIKE:
+		return "ilike"
+	case TOKEN_OP_GLOB:
+		return "glob"
+	case TOKEN_OP_STARTS:
+		return "starts with"
+	case TOKEN_OP_ENDS:
+		return "ends with"
+	case TOKEN_OP_CONTAINS:
+		return "contains"
+	case TOKEN_OP_MATCH:
+		return "~"
+	case TOKEN_OP_NOT_MATCH:
+		return "!~"
+	}
+	return tokenName(op)
+}
+
+// literalString returns the source of a literal value. Floats always keep a
+// fraction or an exponent, so that they are parsed back as floats. NaN and the
+// infinities have no source, they are printed like <NaN>, which Parse rejects.
+func literalString(v any) string {
+	switch v := v.(type) {
+	case int:
+		return strconv.Itoa(v)
+	case uint64:
+		return strconv.FormatUint(v, 10)
+	case float64:
+		s := strconv.FormatFloat(v, 'g', -1, 64)
+		if math.IsInf(v, 0) || math.IsNaN(v) {
+			return "<" + s + ">"
+		} else if !strings.ContainsAny(s, ".e") {
+			s += ".0"
+		}
+		return s
+	case string:
+		return quoteStr(v)
+	case bool:
+		return strconv.FormatBool(v)
+	case nil:
+		return "null"
+	}
+	return "<invalid>"
+}
+
+func listString[T TArg](choices []T) string {
+	items := make([]string, len(choices))
+	for i, choice := range choices {
+		items[i] = literalString(choice)
+	}
+	return "(" + strings.Join(items, ", ") + ")"
+}
+
+func notPrefix(not bool) string {
+	if not {
+		return "not "
+	}
+	return ""
+}
+
+func joinConds(children []BoolAst, sep string, prec int) string {
+	items := make([]string, len(children))
+	for i, child := range children {
+		items[i] = condString(child, prec)
+	}
+	return strings.Join(items, sep)
+}
+
+func (a *ANDs) String() string {
+	return joinConds(a.Children, " and ", precAnd+1)
+}
+
+func (a *ORs) String() string {
+	return joinConds(a.Children, " or ", precOr+1)
+}
+
+func (a *NOT) String() string {
+	return "not " + condString(a.Child, precAtom)
+}
+
+func (c *call[T]) String() string {
+	if c.argCall != nil {
+		return notPrefix(c.not) + c.name + "(" + c.argCall.String() + ")"
+	}
+	return notPrefix(c.not) + c.name + "(" + literalString(c.arg) + ")"
+}
+
+func (c *funcCall) String() string {
+	args := make([]string, len(c.args))
+	for i, arg := range c.args {
+		args[i] = arg.String()
+	}
+	return notPrefix(c.not) + c.name + "(" + strings.Join(args, ", ") + ")"
+}
+
+func (l *Literal) String() string {
+	return literalString(l.Value)
+}
+
+func (a *Arith) String() string {
+	prec := exprPrec(&Arith{Op: a.Op})
+	// the operators are left associative
+	s := exprString(a.Left, prec) + " " + opString(a.Op) + " " + exprString(a.Right, prec+1)
+	return notPrefix(a.not) + s
+}
+
+func (f *Field) String() string {
+	return notPrefix(f.not) + pathString(f.Path)
+}
+
+func (c *IsNull) String() string {
+	if c.NotNull {
+		return c.Call.String() + " is not null"
+	}
+	return c.Call.String() + " is null"
+}
+
+func (c *Compare[T]) String() string {
+	return c.Call.String() + " " + opString(c.Op) + " " + literalString(c.Target)
+}
+
+func (c *StrMatch) String() string {
+	if c.Op == TOKEN_OP_MATCH {
+		op := "~"
+		if c.NotMatch {
+			op = "!~"
+		}
+		return c.Call.String() + " " + op + " " + quoteStr(c.Pattern)
+	}
+	not := ""
+	if c.NotMatch {
+		not = "not "
+	}
+	return c.Call.String() + " " + not + opString(c.Op) + " " + quoteStr(c.Pattern)
+}
+
+func (c *Between) String() string {
+	s := c.Call.String() + " "
+	if c.NotBetween {
+		s += "not "
+	}
+	s += "between " + c.Lower.String() + " and " + c.Upper.String()
+	if c.Exclusive {
+		s += " exclusive"
+	}
+	return s
+}
+
+func (c *In[T]) String() string {
+	if c.NotIn {
+		return c.Call.String() + " not in " + listString(c.Choices)
+	}
+	return c.Call.String() + " in " + listString(c.Choices)
+}
+
+func (c *CompareWithCall) String() string {
+	return c.Left.String() + " " + opString(c.Op) + " " + c.Right.String()
+}
+
+func (c *InWithCall) String() string {
+	if c.NotIn {
+		return c.Left.String() + " not in " + c.Right.String()
+	}
+	return c.Left.String() + " in " + c.Right.String()
+}
+
+func (c *callThenCompare[T1, T2]) String() string {
+	return c.name + "(" + literalString(c.arg) + ") " + opString(c.op) + " " + literalString(c.target)
+}
+
+func (c *callThenIn[T1, T2]) String() string {
+	s := c.name + "(" + literalString(c.arg) + ")"
+	if c.not {
+		return s + " not in " + listString(c.choices)
+	}
+	return s + " in " + listString(c.choices)
+}
@@ -0,0 +1,387 @@
+package filterql
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"sync/atomic"
+)
+
+// ErrorMode decides what the batch functions do when the condition fails on
+// an item.
+type ErrorMode int
+
+const (
+	// StopOnError stops at the first failed item and returns its error.
+	StopOnError ErrorMode = iota
+	// CollectErrors skips the failed items and returns their errors together
+	// as ItemErrors after all the items are done.
+	CollectErrors
+)
+
+// BatchOptions are the options of the batch functions. A nil *BatchOptions
+// is the zero value: sequential, stopping on the first error.
+type BatchOptions struct {
+	// Ctx, ThreeValued and Memoize are copied to the Context of the
+	// evaluation. In three-valued mode an item with an unknown result doesn't
+	// match. The memoized results are kept for one item.
+	Ctx         context.Context
+	ThreeValued bool
+	Memoize     bool
+	ErrorMode   ErrorMode
+	// Workers is the number of goroutines evaluating the items. The matched
+	// items are still returned in the input order.
+	Workers int
+}
+
+// ItemError is the error of the item at Index.
+type ItemError struct {
+	Index int
+	Err   error
+}
+
+func (e *ItemError) Error() string {
+	return fmt.Sprintf("item %d: %v", e.Index, e.Err)
+}
+
+func (e *ItemError) Unwrap() error {
+	return e.Err
+}
+
+// ItemErrors are the errors collected in CollectErrors mode, in the input
+// order.
+type ItemErrors []*ItemError
+
+func (e ItemErrors) Error() string {
+	if len(e) == 1 {
+		return e[0].Error()
+	}
+	return fmt.Sprintf("%d items failed, first %v", len(e), e[0])
+}
+
+func (o *BatchOptions) newContext() *Context {
+	if o == nil {
+		return &Context{}
+	}
+	return &Context{Ctx: o.Ctx, ThreeValued: o.ThreeValued, Memoize: o.Memoize}
+}
+
+func (o *BatchOptions) workers() int {
+	if o == nil || o.Workers < 1 {
+		return 1
+	}
+	return o.Workers
+}
+
+// stops tells if the batch stops on err instead of collecting it
+func (o *BatchOptions) stops(err error) bool {
+	_, canceled := err.(*canceledError)
+	return canceled || o == nil || o.ErrorMode == StopOnError
+}
+
+// match evaluates cond with env, an unknown result doesn't match
+func match(ctx *Context, cond BoolAst, env any) (bool, error) {
+	if err := ctx.canceled(); err != nil {
+		return false, err
+	}
+	ctx.Env = env
+	ctx.Reset()
+	matched, err := cond.IsTrue(ctx)
+	if err == ErrUnknown {
+		return false, nil
+	}
+	return matched, err
+}
+
+// evalSlice evaluates cond with every item as the env, and reports which
+// items matched.
+func evalSlice[T any](cond BoolAst, items []T, opts *BatchOptions) ([]bool, error) {
+	matched := make([]bool, len(items))
+	errs := make([]error, len(items))
+	if workers := opts.workers(); workers == 1 {
+		ctx := opts.newContext()
+		for i, item := range items {
+			if matched[i], errs[i] = match(ctx, cond, item); errs[i] != nil && opts.stops(errs[i]) {
+				break
+			}
+		}
+	} else {
+		var (
+			next    int64 = -1
+			stopped int32
+			wg      sync.WaitGroup
+		)
+		for w := 0; w < workers; w++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				ctx := opts.newContext()
+				for atomic.LoadInt32(&stopped) == 0 {
+					i := int(atomic.AddInt64(&next, 1))
+					if i >= len(items) {
+						return
+					}
+					if matched[i], errs[i] = match(ctx, cond, items[i]); errs[i] != nil && opts.stops(errs[i]) {
+						atomic.StoreInt32(&stopped, 1)
+					}
+				}
+			}()
+		}
+		wg.Wait()
+	}
+	var itemErrs ItemErrors
+	for i, err := range errs {
+		if err == nil {
+			continue
+		} else if opts.stops(err) {
+			return nil, &ItemError{Index: i, Err: err}
+		}
+		itemErrs = append(itemErrs, &ItemError{Index: i, Err: err})
+	}
+	if itemErrs != nil {
+		return matched, itemErrs
+	}
+	return matched, nil
+}
+
+// FilterSlice returns the items matching cond, each item is used as the env
+// as is. In CollectErrors mode the matched items are returned along with the
+// ItemErrors.
+func FilterSlice[T any](cond BoolAst, items []T, opts *BatchOptions) ([]T, error) {
+	matched, err := evalSlice(cond, items, opts)
+	if matched == nil {
+		return nil, err
+	}
+	var result []T
+	for i, item := range items {
+		if matched[i] {
+			result = append(result, item)
+		}
+	}
+	return result, err
+}
+
+// Count returns the number of items matching cond.
+func Count[T any](cond BoolAst, items []T, opts *BatchOptions) (int, error) {
+	matched, err := evalSlice(cond, items, opts)
+	n := 0
+	for _, m := range matched {
+		if m {
+			n++
+		}
+	}
+	return n, err
+}
+
+// First returns the first item matching cond. The items are always
+// evaluated one by one, and none after the first match.
+func First[T any](cond BoolAst, items []T, opts *BatchOptions) (T, bool, error) {
+	var (
+		zero     T
+		itemErrs ItemErrors
+	)
+	ctx := opts.newContext()
+	for i, item := range items {
+		if matched, err := match(ctx, cond, item); err != nil {
+			if opts.stops(err) {
+				return zero, false, &ItemError{Index: i, Err: err}
+			}
+			itemErrs = append(itemErrs, &ItemError{Index: i, Err: err})
+		} else if matched {
+			return item, true, itemErrsOrNil(itemErrs)
+		}
+	}
+	return zero, false, itemErrsOrNil(itemErrs)
+}
+
+func itemErrsOrNil(errs ItemErrors) error {
+	if errs == nil {
+		return nil
+	}
+	return errs
+}
+
+// Iterator yields the items of a source matching a condition. It's not safe
+// for concurrent use.
+type Iterator[T any] struct {
+	cond     BoolAst
+	next     func() (T, bool)
+	opts     *BatchOptions
+	ctx      *Context
+	index    int
+	item     T
+	err      error
+	itemErrs ItemErrors
+}
+
+// FilterIter returns an Iterator over the items returned by next until it
+// returns false.
+func FilterIter[T any](cond BoolAst, next func() (T, bool), opts *BatchOptions) *Iterator[T] {
+	return &Iterator[T]{cond: cond, next: next, opts: opts, ctx: opts.newContext()}
+}
+
+// Next moves to the next matched item. It returns false when the source is
+// exhausted or the iteration stops on an error.
+func (it *Iterator[T]) Next() bool {
+	if it.err != nil {
+		return false
+	}
+	for {
+		item, ok := it.next()
+		if !ok {
+			it.err = itemErrsOrNil(it.itemErrs)
+			return false
+		}
+		i := it.index
+		it.index++
+		if matched, err := match(it.ctx, it.cond, item); err != nil {
+			if it.opts.stops(err) {
+				it.err = &ItemError{Index: i, Err: err}
+				return false
+			}
+			it.itemErrs = append(it.itemErrs, &ItemError{Index: i, Err: err})
+		} else if matched {
+			it.item = item
+			return true
+		}
+	}
+}
+
+// Item returns the current matched item.
+func (it *Iterator[T]) Item() T {
+	return it.item
+}
+
+// Err returns the error which stopped the iteration, or the collected
+// ItemErrors once the source is exhausted.
+func (it *Iterator[T]) Err() error {
+	return it.err
+}
+
+// FilterChan sends the items from in matching cond to the returned channel,
+// which is closed after in is closed or the filtering stops on an error. The
+// error channel then receives the final error, if any, and is closed. The
+// output channel must be drained unless Ctx is canceled. After an early stop
+// the rest of in is received and dropped, so the producer doesn't block, until
+// in is closed.
+func FilterChan[T any](cond BoolAst, in <-chan T, opts *BatchOptions) (<-chan T, <-chan error) {
+	out := make(chan T)
+	errc := make(chan error, 1)
+	var done <-chan struct{}
+	if opts != nil && opts.Ctx != nil {
+		done = opts.Ctx.Done()
+	}
+	go func() {
+		defer close(errc)
+		defer close(out)
+		var (
+			itemErrs ItemErrors
+			err      error
+		)
+		emit := func(i int, item T, matched bool, merr error) bool {
+			if merr != nil {
+				if opts.stops(merr) {
+					err = &ItemError{Index: i, Err: merr}
+					return false
+				}
+				itemErrs = append(itemErrs, &ItemError{Index: i, Err: merr})
+			} else if matched {
+				select {
+				case out <- item:
+				case <-done:
+					err = &ItemError{Index: i, Err: &canceledError{cause: opts.Ctx.Err()}}
+					return false
+				}
+			}
+			return true
+		}
+		if opts.workers() == 1 {
+			filterChanSeq(cond, in, opts, emit)
+		} else {
+			filterChanParallel(cond, in, opts, emit)
+		}
+		if err == nil {
+			err = itemErrsOrNil(itemErrs)
+		}
+		if err != nil {
+			errc <- err
+		}
+	}()
+	return out, errc
+}
+
+func filterChanSeq[T any](cond BoolAst, in <-chan T, opts *BatchOptions,
+	emit func(int, T, bool, error) bool) {
+	ctx := opts.newContext()
+	i := 0
+	for item := range in {
+		matched, err := match(ctx, cond, item)
+		if !emit(i, item, matched, err) {
+			go drainChan(in)
+			return
+		}
+		i++
+	}
+}
+
+// drainChan receives from in until it's closed.
+func drainChan[T any](in <-chan T) {
+	for range in {
+	}
+}
+
+// chanItem is an item being evaluated by the workers of FilterChan
+type chanItem[T any] struct {
+	index   int
+	item    T
+	matched bool
+	err     error
+	done    chan struct{}
+}
+
+func filterChanParallel[T any](cond BoolAst, in <-chan T, opts *BatchOptions,
+	emit func(int, T, bool, error) bool) {
+	workers := opts.workers()
+	// pending keeps the items in the input order, its capacity limits the
+	// number of items evaluated ahead of the one to emit next
+	pending := make(chan *chanItem[T], workers)
+	jobs := make(chan *chanItem[T])
+	stop := make(chan struct{})
+	defer close(stop)
+	go func() {
+		defer close(pending)
+		defer close(jobs)
+		i := 0
+		for item := range in {
+			ci := &chanItem[T]{index: i, item: item, done: make(chan struct{})}
+			i++
+			select {
+			case pending <- ci:
+			case <-stop:
+				go drainChan(in)
+				return
+			}
+			select {
+			case jobs <- ci:
+			case <-stop:
+				go drainChan(in)
+				return
+			}
+		}
+	}()
+	for w := 0; w < workers; w++ {
+		go func() {
+			ctx := opts.newContext()
+			for ci := range jobs {
+				ci.matched, ci.err = match(ctx, cond, ci.item)
+				close(ci.done)
+			}
+		}()
+	}
+	for ci := range pending {
+		<-ci.done
+		if !emit(ci.index, ci.item, ci.matched, ci.err) {
+			return
+		}
+	}
+}
@@ -0,0 +1,199 @@
+package filterql_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	fql "github.com/lennon-guan/filterql"
+)
+
+func recordPtrs() []*Record {
+	ptrs := make([]*Record, len(records))
+	for i := range records {
+		ptrs[i] = &records[i]
+	}
+	return ptrs
+}
+
+func recordIds(recs []*Record) string {
+	ids := make([]int, len(recs))
+	for i, rec := range recs {
+		ids[i] = rec.ID
+	}
+	return joinInts(ids)
+}
+
+func mustParse(t *testing.T, query string) fql.BoolAst {
+	cond, err := fql.Parse(query, cfg)
+	if err != nil {
+		t.Fatalf("parse %s error %+v", query, err)
+	}
+	return cond
+}
+
+func TestFilterSlice(t *testing.T) {
+	cond := mustParse(t, "rec('Level') >= 8 and rec('Source') < 4")
+	for _, workers := range []int{0, 1, 3, 16} {
+		opts := &fql.BatchOptions{Workers: workers}
+		if matched, err := fql.FilterSlice(cond, recordPtrs(), opts); err != nil {
+			t.Errorf("workers %d error %+v", workers, err)
+		} else if got := recordIds(matched); got != "1,3,4,5" {
+			t.Errorf("workers %d got %s", workers, got)
+		}
+		if n, err := fql.Count(cond, recordPtrs(), opts); err != nil || n != 4 {
+			t.Errorf("workers %d count %d %+v", workers, n, err)
+		}
+	}
+	if rec, found, err := fql.First(cond, recordPtrs()[1:], nil); err != nil || !found || rec.ID != 3 {
+		t.Errorf("first got %v %v %+v", rec, found, err)
+	}
+	if _, found, err := fql.First(mustParse(t, "rec('ID') > 100"), recordPtrs(), nil); err != nil || found {
+		t.Errorf("first expected not found but got %v %+v", found, err)
+	}
+}
+
+func TestFilterSliceErrors(t *testing.T) {
+	// opt is null for the records not from source 1
+	cond := mustParse(t, "opt('Level') > 7")
+	for _, workers := range []int{1, 4} {
+		_, err := fql.FilterSlice(cond, recordPtrs(), &fql.BatchOptions{Workers: workers})
+		var itemErr *fql.ItemError
+		if !errors.As(err, &itemErr) || itemErr.Index != 3 || !errors.Is(err, fql.ErrTypeNotMatched) {
+			t.Errorf("workers %d expected error of item 3 but got %+v", workers, err)
+		}
+
+		matched, err := fql.FilterSlice(cond, recordPtrs(), &fql.BatchOptions{Workers: workers, ErrorMode: fql.CollectErrors})
+		if got := recordIds(matched); got != "1,3" {
+			t.Errorf("workers %d got %s", workers, got)
+		}
+		if itemErrs, is := err.(fql.ItemErrors); !is || len(itemErrs) != 4 || itemErrs[0].Index != 3 {
+			t.Errorf("workers %d expected 4 item errors but got %+v", workers, err)
+		}
+
+		matched, err = fql.FilterSlice(cond, recordPtrs(), &fql.BatchOptions{Workers: workers, ThreeValued: true})
+		if got := recordIds(matched); err != nil || got != "1,3" {
+			t.Errorf("workers %d three-valued got %s %+v", workers, got, err)
+		}
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err := fql.FilterSlice(cond, recordPtrs(), &fql.BatchOptions{Ctx: ctx, ErrorMode: fql.CollectErrors})
+	if !errors.Is(err, fql.ErrCanceled) {
+		t.Errorf("expected canceled but got %+v", err)
+	}
+}
+
+func TestFilterIter(t *testing.T) {
+	recs := recordPtrs()
+	i := 0
+	it := fql.FilterIter(mustParse(t, "opt('Level') > 7"), func() (*Record, bool) {
+		if i == len(recs) {
+			return nil, false
+		}
+		i++
+		return recs[i-1], true
+	}, &fql.BatchOptions{ErrorMode: fql.CollectErrors})
+	var matched []*Record
+	for it.Next() {
+		matched = append(matched, it.Item())
+	}
+	if got := recordIds(matched); got != "1,3" {
+		t.Errorf("got %s", got)
+	}
+	if itemErrs, is := it.Err().(fql.ItemErrors); !is || len(itemErrs) != 4 {
+		t.Errorf("expected 4 item errors but got %+v", it.Err())
+	}
+}
+
+func TestFilterChan(t *testing.T) {
+	for _, workers := range []int{1, 3} {
+		in := make(chan *Record)
+		go func() {
+			for i := 0; i < 10; i++ {
+				for _, rec := range recordPtrs() {
+					in <- rec
+				}
+			}
+			close(in)
+		}()
+		out, errc := fql.FilterChan(mustParse(t, "rec('Source') in (1, 3)"), in, &fql.BatchOptions{Workers: workers})
+		var matched []*Record
+		for rec := range out {
+			matched = append(matched, rec)
+		}
+		if err := <-errc; err != nil {
+			t.Errorf("workers %d error %+v", workers, err)
+		}
+		if len(matched) != 40 {
+			t.Fatalf("workers %d got %d records", workers, len(matched))
+		}
+		for i := 0; i < 10; i++ {
+			if got := recordIds(matched[i*4 : i*4+4]); got != "1,2,3,6" {
+				t.Errorf("workers %d got %s at %d", workers, got, i)
+			}
+		}
+
+		in = make(chan *Record, len(records))
+		for _, rec := range recordPtrs() {
+			in <- rec
+		}
+		close(in)
+		out, errc = fql.FilterChan(mustParse(t, "opt('Level') > 7"), in, &fql.BatchOptions{Workers: workers})
+		matched = matched[:0]
+		for rec := range out {
+			matched = append(matched, rec)
+		}
+		var itemErr *fql.ItemError
+		if err := <-errc; !errors.As(err, &itemErr) || itemErr.Index != 3 {
+			t.Errorf("workers %d expected error of item 3 but got %+v", workers, err)
+		}
+		if got := recordIds(matched); got != "1,3" {
+			t.Errorf("workers %d got %s", workers, got)
+		}
+	}
+}
+
+func TestFilterChanEarlyStop(t *testing.T) {
+	for _, workers := range []int{1, 3} {
+		for name, opts := range map[string]*fql.BatchOptions{
+			"error":  {Workers: workers},
+			"cancel": {Workers: workers},
+		} {
+			query := "opt('Level') > 7"
+			var cancel context.CancelFunc
+			if name == "cancel" {
+				query = "rec('Level') > 0"
+				opts.Ctx, cancel = context.WithCancel(context.Background())
+			}
+			in := make(chan *Record)
+			sent := make(chan struct{})
+			go func() {
+				defer close(sent)
+				for i := 0; i < 100; i++ {
+					for _, rec := range recordPtrs() {
+						in <- rec
+					}
+				}
+				close(in)
+			}()
+			out, errc := fql.FilterChan(mustParse(t, query), in, opts)
+			if cancel != nil {
+				<-out
+				cancel()
+			}
+			for range out {
+			}
+			if err := <-errc; err == nil {
+				t.Errorf("workers %d %s expected an error", workers, name)
+			}
+			select {
+			case <-sent:
+			case <-time.After(5 * time.Second):
+				t.Fatalf("workers %d %s the producer is blocked", workers, name)
+			}
+		}
+	}
+}
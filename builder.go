@@ -0,0 +1,366 @@
+package filterql
+
+import (
+	"fmt"
+	"reflect"
+	"strconv"
+)
+
+// Builder builds filters in Go code. The calls and fields are bound to the
+// methods of its ParseConfig and checked the same way as in Parse, e.g.
+//
+//	b := NewBuilder(cfg)
+//	cond, err := b.And(b.Parse(query), b.Call("rec", "Tenant").Eq(5)).Build()
+//
+// Values are given as Go values (ints, floats, strings and bools) or as
+// Exprs. An error is carried along to Build, so the calls can be chained.
+type Builder struct {
+	cfg *ParseConfig
+}
+
+func NewBuilder(cfg *ParseConfig) *Builder {
+	if cfg == nil {
+		cfg = &defaultConfig
+	}
+	return &Builder{cfg: cfg}
+}
+
+// Expr is an operand built by a Builder: a call, a field, a literal or an
+// arithmetic operation.
+type Expr struct {
+	b    *Builder
+	call Call
+	err  error
+}
+
+// Build returns the operand, or the first error met while building it.
+func (e Expr) Build() (Call, error) {
+	if err := e.check(); err != nil {
+		return nil, err
+	}
+	return e.call, nil
+}
+
+// check returns the error of the operand. A zero Expr, which is not built by
+// a Builder, is invalid.
+func (e Expr) check() error {
+	if e.err != nil {
+		return e.err
+	} else if e.b == nil || e.call == nil {
+		return fmt.Errorf("zero Expr: %w", ErrInvalidFilter)
+	}
+	return nil
+}
+
+// Cond is a condition built by a Builder.
+type Cond struct {
+	cond BoolAst
+	err  error
+}
+
+// Build returns the condition, or the first error met while building it.
+func (c Cond) Build() (BoolAst, error) {
+	if err := c.check(); err != nil {
+		return nil, err
+	}
+	return c.cond, nil
+}
+
+// check returns the error of the condition. A zero Cond is invalid.
+func (c Cond) check() error {
+	if c.err != nil {
+		return c.err
+	} else if c.cond == nil {
+		return fmt.Errorf("zero Cond: %w", ErrInvalidFilter)
+	}
+	return nil
+}
+
+func (b *Builder) expr(c Call, err error) Expr {
+	if err != nil {
+		return Expr{b: b, err: bareError(err)}
+	}
+	return Expr{b: b, call: c}
+}
+
+func condOf(c BoolAst, err error) Cond {
+	if err != nil {
+		return Cond{err: bareError(err)}
+	}
+	return Cond{cond: c}
+}
+
+// valueToken returns the literal token of a Go value, so that it's converted
+// the same way as in a parsed query. NaN and the infinities have no literal,
+// they are an ErrInvalidFilter.
+func valueToken(v any) (TokenInfo, error) {
+	switch v := v.(type) {
+	case int, int8, int16, int32, int64, uint, uint8, uint16, uint32, uint64:
+		return TokenInfo{Type: TOKEN_INT, Text: []rune(fmt.Sprint(v))}, nil
+	case float32:
+		if !isFinite(float64(v)) {
+			return TokenInfo{}, fmt.Errorf("value %v: %w", v, ErrInvalidFilter)
+		}
+		return TokenInfo{Type: TOKEN_FLOAT, Text: []rune(strconv.FormatFloat(float64(v), 'g', -1, 32))}, nil
+	case float64:
+		if !isFinite(v) {
+			return TokenInfo{}, fmt.Errorf("value %v: %w", v, ErrInvalidFilter)
+		}
+		return TokenInfo{Type: TOKEN_FLOAT, Text: []rune(strconv.FormatFloat(v, 'g', -1, 64))}, nil
+	case string:
+		return TokenInfo{Type: TOKEN_STR, Text: []rune(quoteStr(v))}, nil
+	case bool:
+		if v {
+			return TokenInfo{Type: TOKEN_TRUE, Text: []rune("true")}, nil
+		}
+		return TokenInfo{Type: TOKEN_FALSE, Text: []rune("false")}, nil
+	}
+	return TokenInfo{}, fmt.Errorf("value %v of %T: %w", v, v, ErrTypeNotMatched)
+}
+
+// operand returns v as an operand, v is an Expr or a Go value.
+func (b *Builder) operand(v any) (Call, error) {
+	if e, is := v.(Expr); is {
+		return e.call, e.check()
+	}
+	tok, err := valueToken(v)
+	if err != nil {
+		return nil, err
+	}
+	lit, err := literalOf(tok, TypeAny)
+	if err != nil {
+		return nil, bareError(err)
+	}
+	return &Literal{Value: lit}, nil
+}
+
+// Call is a call of the method name with args.
+func (b *Builder) Call(name string, args ...any) Expr {
+	list := make([]callArg, len(args))
+	for i, arg := range args {
+		if e, is := arg.(Expr); is {
+			if err := e.check(); err != nil {
+				return b.expr(nil, err)
+			}
+			list[i].call = e.call
+		} else if tok, err := valueToken(arg); err != nil {
+			return b.expr(nil, fmt.Errorf("%s: %w", name, err))
+		} else {
+			list[i].tok = tok
+		}
+	}
+	c, err := bindCall(b.cfg, name, 0, list)
+	if err != nil {
+		return b.expr(nil, fmt.Errorf("%s: %w", name, bareError(err)))
+	}
+	return b.expr(c, nil)
+}
+
+// Field is the field at path, written as in a query like "user.tags[0]".
+func (b *Builder) Field(path string) Expr {
+	segs, err := parsePath(path)
+	if err != nil {
+		return b.expr(nil, err)
+	}
+	field, err := bindField(b.cfg, segs, 0)
+	if err != nil {
+		return b.expr(nil, err)
+	}
+	return b.expr(field, nil)
+}
+
+// Value is a literal operand.
+func (b *Builder) Value(v any) Expr {
+	return b.expr(b.operand(v))
+}
+
+// Parse parses query with the config of the builder.
+func (b *Builder) Parse(query string) Cond {
+	return condOf(Parse(query, b.cfg))
+}
+
+// From wraps a condition built otherwise.
+func (b *Builder) From(cond BoolAst) Cond {
+	return Cond{cond: cond}
+}
+
+func (b *Builder) And(conds ...Cond) Cond {
+	children, err := flattenConds(conds, func(c BoolAst) []BoolAst {
+		if a, is := c.(*ANDs); is {
+			return a.Children
+		}
+		return nil
+	})
+	if err != nil || len(children) == 1 {
+		return condOf(children[0], err)
+	}
+	return Cond{cond: &ANDs{Children: children}}
+}
+
+func (b *Builder) Or(conds ...Cond) Cond {
+	children, err := flattenConds(conds, func(c BoolAst) []BoolAst {
+		if a, is := c.(*ORs); is {
+			return a.Children
+		}
+		return nil
+	})
+	if err != nil || len(children) == 1 {
+		return condOf(children[0], err)
+	}
+	return Cond{cond: &ORs{Children: children}}
+}
+
+// flattenConds merges the children of the nested nodes of the same kind, as
+// the parser does.
+func flattenConds(conds []Cond, nested func(BoolAst) []BoolAst) ([]BoolAst, error) {
+	if len(conds) == 0 {
+		return []BoolAst{nil}, fmt.Errorf("no conditions: %w", ErrInvalidFilter)
+	}
+	var children []BoolAst
+	for _, c := range conds {
+		if err := c.check(); err != nil {
+			return []BoolAst{nil}, err
+		} else if list := nested(c.cond); list != nil {
+			children = append(children, list...)
+		} else {
+			children = append(children, c.cond)
+		}
+	}
+	return children, nil
+}
+
+func (b *Builder) Not(c Cond) Cond {
+	if err := c.check(); err != nil {
+		return Cond{err: err}
+	}
+	return Cond{cond: c.cond.Not()}
+}
+
+// Cond uses the operand as a condition, which is true if its value is truthy.
+func (e Expr) Cond() Cond {
+	return condOf(e.call, e.check())
+}
+
+func (e Expr) compare(op int, v any) Cond {
+	if err := e.check(); err != nil {
+		return Cond{err: err}
+	}
+	right, err := e.b.operand(v)
+	if err != nil {
+		return Cond{err: err}
+	} else if op == TOKEN_OP_MATCH {
+		return condOf(newRegexpMatch(e.b.cfg, e.call, op, right, 0))
+	}
+	return condOf(newCompare(e.b.cfg, e.call, op, right, 0))
+}
+
+func (e Expr) Eq(v any) Cond { return e.compare(TOKEN_OP_EQ, v) }
+func (e Expr) Ne(v any) Cond { return e.compare(TOKEN_OP_NE, v) }
+func (e Expr) Gt(v any) Cond { return e.compare(TOKEN_OP_GT, v) }
+func (e Expr) Ge(v any) Cond { return e.compare(TOKEN_OP_GE, v) }
+func (e Expr) Lt(v any) Cond { return e.compare(TOKEN_OP_LT, v) }
+func (e Expr) Le(v any) Cond { return e.compare(TOKEN_OP_LE, v) }
+
+// Matches checks the operand against the regular expression pattern, which
+// is a string or an Expr.
+func (e Expr) Matches(pattern any) Cond { return e.compare(TOKEN_OP_MATCH, pattern) }
+
+// In checks that the operand is one of values. The values are literals of
+// the same kind, or a slice of them, or a single Expr evaluated to a list.
+func (e Expr) In(values ...any) Cond { return e.in(values, false) }
+
+func (e Expr) NotIn(values ...any) Cond { return e.in(values, true) }
+
+func (e Expr) in(values []any, not bool) Cond {
+	if err := e.check(); err != nil {
+		return Cond{err: err}
+	}
+	if len(values) == 1 {
+		if list, is := values[0].(Expr); is {
+			if err := list.check(); err != nil {
+				return Cond{err: err}
+			}
+			return condOf(newInWithCall(e.b.cfg, e.call, list.call, not, 0))
+		} else if rv := reflect.ValueOf(values[0]); rv.Kind() == reflect.Slice || rv.Kind() == reflect.Array {
+			values = make([]any, rv.Len())
+			for i := range values {
+				values[i] = rv.Index(i).Interface()
+			}
+		}
+	}
+	if len(values) == 0 {
+		return Cond{err: fmt.Errorf("no choices: %w", ErrInvalidFilter)}
+	}
+	choices := make([]TokenInfo, len(values))
+	for i, v := range values {
+		tok, err := valueToken(v)
+		if err != nil {
+			return Cond{err: err}
+		}
+		choices[i] = tok
+	}
+	return condOf(newChoices(e.b.cfg, e.call, choices, not))
+}
+
+func (e Expr) IsNull() Cond    { return e.isNull(false) }
+func (e Expr) IsNotNull() Cond { return e.isNull(true) }
+
+func (e Expr) isNull(not bool) Cond {
+	if err := e.check(); err != nil {
+		return Cond{err: err}
+	}
+	return Cond{cond: &IsNull{Call: e.call, NotNull: not}}
+}
+
+func (e Expr) Like(pattern string) Cond      { return e.match(TOKEN_OP_LIKE, pattern) }
+func (e Expr) ILike(pattern string) Cond     { return e.match(TOKEN_OP_ILIKE, pattern) }
+func (e Expr) Glob(pattern string) Cond      { return e.match(TOKEN_OP_GLOB, pattern) }
+func (e Expr) StartsWith(prefix string) Cond { return e.match(TOKEN_OP_STARTS, prefix) }
+func (e Expr) EndsWith(suffix string) Cond   { return e.match(TOKEN_OP_ENDS, suffix) }
+func (e Expr) Contains(substr string) Cond   { return e.match(TOKEN_OP_CONTAINS, substr) }
+
+func (e Expr) match(op int, pattern string) Cond {
+	if err := e.check(); err != nil {
+		return Cond{err: err}
+	}
+	return condOf(newCheckedStrMatch(e.b.cfg, e.call, op, pattern, false, 0, 0))
+}
+
+// Between checks that the operand is within lower and upper, both included.
+func (e Expr) Between(lower, upper any) Cond { return e.between(lower, upper, false) }
+
+// BetweenExclusive checks that the operand is within lower and upper, both
+// excluded.
+func (e Expr) BetweenExclusive(lower, upper any) Cond { return e.between(lower, upper, true) }
+
+func (e Expr) between(lower, upper any, exclusive bool) Cond {
+	if err := e.check(); err != nil {
+		return Cond{err: err}
+	}
+	lo, err := e.b.operand(lower)
+	if err != nil {
+		return Cond{err: err}
+	}
+	hi, err := e.b.operand(upper)
+	if err != nil {
+		return Cond{err: err}
+	}
+	return condOf(newBetween(e.b.cfg, e.call, lo, hi, exclusive, false, 0, 0))
+}
+
+func (e Expr) Add(v any) Expr { return e.arith(TOKEN_OP_ADD, v) }
+func (e Expr) Sub(v any) Expr { return e.arith(TOKEN_OP_SUB, v) }
+func (e Expr) Mul(v any) Expr { return e.arith(TOKEN_OP_MUL, v) }
+func (e Expr) Div(v any) Expr { return e.arith(TOKEN_OP_DIV, v) }
+func (e Expr) Mod(v any) Expr { return e.arith(TOKEN_OP_MOD, v) }
+
+func (e Expr) arith(op int, v any) Expr {
+	if err := e.check(); err != nil {
+		return Expr{b: e.b, err: err}
+	}
+	right, err := e.b.operand(v)
+	if err != nil {
+		return e.b.expr(nil, err)
+	}
+	return e.b.expr(newArith(e.b.cfg, e.call, op, right, 0))
+}
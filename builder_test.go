@@ -0,0 +1,169 @@
+package filterql_test
+
+import (
+	"errors"
+	"math"
+	"testing"
+
+	fql "github.com/lennon-guan/filterql"
+)
+
+func testBuilt(t *testing.T, cond fql.Cond, expected string, expectedIds ...int) {
+	t.Helper()
+	built, err := cond.Build()
+	if err != nil {
+		t.Errorf("build %s error %+v", expected, err)
+		return
+	}
+	if s := built.String(); s != expected {
+		t.Errorf("expected %s but built %s", expected, s)
+	}
+	var ids []int
+	for i := range records {
+		if matched, err := built.IsTrue(fql.NewContext(&records[i])); err != nil {
+			t.Errorf("%s on record %d error %+v", expected, i, err)
+		} else if matched {
+			ids = append(ids, records[i].ID)
+		}
+	}
+	if got, want := joinInts(ids), joinInts(expectedIds); got != want {
+		t.Errorf("%s expected %s but got %s", expected, want, got)
+	}
+}
+
+func TestBuilder(t *testing.T) {
+	b := fql.NewBuilder(cfg)
+	rec := func(field string) fql.Expr { return b.Call("rec", field) }
+	testBuilt(t, rec("Source").Eq(1), "rec('Source') = 1", 1, 2, 3)
+	testBuilt(t, rec("Source").In(2, 3), "rec('Source') in (2, 3)", 4, 5, 6)
+	testBuilt(t, rec("Source").NotIn([]int{1, 2, 4}), "rec('Source') not in (1, 2, 4)", 6)
+	testBuilt(t, rec("Score").In(4, 4.5), "rec('Score') in (4.0, 4.5)", 1, 6)
+	testBuilt(t, b.And(rec("Level").Ge(8), b.Not(rec("Name").Like("%e%"))),
+		"rec('Level') >= 8 and rec('Name') not like '%e%'", 4, 5)
+	testBuilt(t, b.Or(b.Parse("rec('ID') = 1 or rec('ID') = 2"), rec("Name").Matches("^G")),
+		"rec('ID') = 1 or rec('ID') = 2 or rec('Name') ~ '^G'", 1, 2, 7)
+	testBuilt(t, b.And(b.Parse("rec('Source') = 1"), b.And(rec("Level").Lt(10), rec("ID").Ne(3))),
+		"rec('Source') = 1 and rec('Level') < 10 and rec('ID') <> 3", 2)
+	testBuilt(t, rec("Level").Mul(2).Add(b.Field("ID")).Gt(b.Value(25)),
+		"rec('Level') * 2 + ID > 25", 5, 7)
+	testBuilt(t, b.Call("double", b.Field("ID")).Between(4, b.Value(8)),
+		"double(ID) between 4 and 8", 2, 3, 4)
+	testBuilt(t, rec("Level").BetweenExclusive(8, 11), "rec('Level') between 8 and 11 exclusive", 1)
+	testBuilt(t, b.Call("bucket", "ID", 3).Eq(b.Call("one")), "bucket('ID', 3) = one()", 1, 4, 7)
+	testBuilt(t, rec("Source").In(b.Call("arg", "sources")), "rec('Source') in arg('sources')", 1, 2, 3, 6)
+	testBuilt(t, b.Call("opt", "Name").IsNotNull(), "opt('Name') is not null", 1, 2, 3)
+	testBuilt(t, b.Not(b.Call("env", "one_or_three").Cond()), "not env('one_or_three')", 4, 5, 7)
+	testBuilt(t, b.And(rec("Name").StartsWith("D"), rec("Name").Contains("Fr"), rec("Name").EndsWith("t")),
+		"rec('Name') starts with 'D' and rec('Name') contains 'Fr' and rec('Name') ends with 't'", 4)
+	testBuilt(t, b.Or(rec("Name").ILike("a%"), rec("Name").Glob("*g")), "rec('Name') ilike 'a%' or rec('Name') glob '*g'", 1, 5, 6)
+}
+
+func TestBuilderError(t *testing.T) {
+	b := fql.NewBuilder(cfg)
+	for name, c := range map[string]fql.Cond{
+		"unknown method": b.And(b.Call("rec", "ID").Eq(1), b.Call("nope", 1).Eq(2)),
+		"nested unknown": b.Call("double", b.Call("nope")).Gt(1),
+		"bad arg type":   b.Call("bucket", 1, 3).Eq(0),
+		"mixed choices":  b.Call("rec", "ID").In(1, "a"),
+		"bad value":      b.Call("rec", "ID").Eq([]int{1}),
+		"bad regexp":     b.Call("rec", "Name").Matches("("),
+		"bad arith":      b.Value("a").Mul(2).Gt(1),
+		"empty and":      b.And(),
+		"empty in":       b.Call("rec", "ID").In(),
+		"bad parse":      b.Or(b.Parse("rec('ID') = "), b.Call("rec", "ID").Eq(1)),
+		"compare bool":   b.Call("rec", "ID").Gt(true),
+	} {
+		if cond, err := c.Build(); err == nil {
+			t.Errorf("%s expected error but got %s", name, cond)
+		}
+	}
+	_, err := b.Call("nope", 1).Eq(2).Build()
+	if !errors.Is(err, fql.ErrNoSuchMethod) {
+		t.Errorf("expected ErrNoSuchMethod but got %+v", err)
+	}
+
+	var zero fql.Expr
+	for name, c := range map[string]fql.Cond{
+		"not zero":     b.Not(fql.Cond{}),
+		"and zero":     b.And(b.Call("rec", "ID").Eq(1), fql.Cond{}),
+		"zero eq":      zero.Eq(1),
+		"zero in":      zero.In(1, 2),
+		"in zero":      b.Call("rec", "ID").In(zero),
+		"zero is null": zero.IsNull(),
+		"zero like":    zero.Like("a%"),
+		"zero between": zero.Between(1, 2),
+		"zero arith":   zero.Add(1).Gt(1),
+		"eq zero":      b.Call("rec", "ID").Eq(zero),
+		"call zero":    b.Call("double", zero).Eq(1),
+		"zero cond":    zero.Cond(),
+		"zero":         fql.Cond{},
+		"nan":          b.Call("rec", "Score").Gt(math.NaN()),
+		"inf":          b.Call("rec", "Score").Lt(math.Inf(1)),
+		"inf32":        b.Value(float32(math.Inf(-1))).Lt(1),
+		"inf choice":   b.Call("rec", "Score").In(1.5, math.Inf(1)),
+		"nan arg":      b.Call("level_div", math.NaN()).Gt(1),
+	} {
+		if cond, err := c.Build(); !errors.Is(err, fql.ErrInvalidFilter) {
+			t.Errorf("%s expected ErrInvalidFilter but got %v %+v", name, cond, err)
+		}
+	}
+	if _, err := zero.Build(); !errors.Is(err, fql.ErrInvalidFilter) {
+		t.Errorf("expected ErrInvalidFilter but got %+v", err)
+	}
+}
+
+func TestRewrite(t *testing.T) {
+	b := fql.NewBuilder(cfg)
+	cond, err := fql.Parse("rec('Source') = 1 and (opt('Level') + 0 > 7 or rec('Name') in ('Fig', 'Grape'))", cfg)
+	if err != nil {
+		t.Fatalf("parse error %+v", err)
+	}
+	// rec('Name') becomes lower(rec('Name')) and opt(...) becomes rec(...)
+	rewritten, err := fql.Rewrite(cond, func(node fql.PrintableAst) (fql.PrintableAst, error) {
+		switch c := node.(type) {
+		case fql.MethodCall:
+			if c.Name() == "opt" {
+				return b.Call("rec", c.Args()[0].(*fql.Literal).Value).Build()
+			}
+		case fql.CallIn:
+			if c.Name() == "rec" && c.Args()[0].(*fql.Literal).Value == "Name" {
+				return b.Call("lower", b.Call("rec", "Name")).In("fig", "grape").Build()
+			}
+		}
+		return node, nil
+	})
+	if err != nil {
+		t.Fatalf("rewrite error %+v", err)
+	}
+	testBuilt(t, b.From(rewritten), "rec('Source') = 1 and (rec('Level') + 0 > 7 or lower(rec('Name')) in ('fig', 'grape'))", 1, 3)
+	if s := cond.String(); s != "rec('Source') = 1 and (opt('Level') + 0 > 7 or rec('Name') in ('Fig', 'Grape'))" {
+		t.Errorf("original changed to %s", s)
+	}
+
+	// the literal argument of a merged call is replaced by a call
+	rewritten, err = fql.Rewrite(cond, func(node fql.PrintableAst) (fql.PrintableAst, error) {
+		if lit, is := node.(*fql.Literal); is && lit.Value == "Source" {
+			return b.Call("lower", "SOURCE").Build()
+		}
+		return node, nil
+	})
+	if err != nil {
+		t.Fatalf("rewrite error %+v", err)
+	}
+	if s := rewritten.String(); s != "rec(lower('SOURCE')) = 1 and (opt('Level') + 0 > 7 or rec('Name') in ('Fig', 'Grape'))" {
+		t.Errorf("unexpected rewritten %s", s)
+	}
+
+	_, err = fql.Rewrite(cond, func(node fql.PrintableAst) (fql.PrintableAst, error) {
+		if _, is := node.(fql.CallCompare); is {
+			return b.Call("rec", "ID").Eq(1).Build()
+		} else if _, is := node.(fql.MethodCall); is {
+			// a condition where an operand is expected
+			return b.Call("rec", "ID").Eq(1).Build()
+		}
+		return node, nil
+	})
+	if !errors.Is(err, fql.ErrTypeNotMatched) {
+		t.Errorf("expected ErrTypeNotMatched but got %+v", err)
+	}
+}
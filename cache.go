@@ -2,6 +2,7 @@ package filterql
 
 import (
 	"container/list"
+	"regexp"
 	"sync"
 )
 
@@ -94,3 +95,33 @@ func (c *lruCache) Store(query string, cond BoolAst) {
 		}
 	}
 }
+
+// regexpCache keeps regexps compiled while evaluating. It's simply emptied
+// when it grows too big.
+type regexpCache struct {
+	lock     sync.RWMutex
+	capacity int
+	m        map[string]*regexp.Regexp
+}
+
+var regexps = &regexpCache{capacity: 1024, m: make(map[string]*regexp.Regexp)}
+
+func (c *regexpCache) get(pattern string) (*regexp.Regexp, error) {
+	c.lock.RLock()
+	re, found := c.m[pattern]
+	c.lock.RUnlock()
+	if found {
+		return re, nil
+	}
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return nil, err
+	}
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if len(c.m) >= c.capacity {
+		c.m = make(map[string]*regexp.Regexp)
+	}
+	c.m[pattern] = re
+	return re, nil
+}
@@ -14,6 +14,9 @@ func main() {
 		DefaultStrMethod: func(string, any, string) (any, error) {
 			return "", nil
 		},
+		DefaultFloatMethod: func(string, any, float64) (any, error) {
+			return 0.0, nil
+		},
 	})
 	if err != nil {
 		panic(err)
@@ -0,0 +1,193 @@
+package filterql
+
+import (
+	"fmt"
+	"reflect"
+	"unsafe"
+)
+
+// Filter is a condition compiled for records of type T.
+type Filter[T any] struct {
+	cond BoolAst
+}
+
+// Compile parses query for records of the struct type T. The exported fields
+// of T can be used by name in the query, like Level in Level > 5, or by a path
+// like Owner.Team or Tags[0] through structs, pointers, slices and arrays. The
+// paths are resolved here into the indexes of the fields, so nothing is looked
+// up by name per record. A path must end at a basic type, a pointer to one,
+// []string, []int or any; the other types are a ParseError of
+// ErrUnsupportedField. An unknown field is a ParseError of ErrNoSuchField. The
+// methods of cfg are still available and get a *T as the env. cfg.Cache is not
+// used, since it would mix up conditions compiled for different types.
+func Compile[T any](query string, cfg *ParseConfig) (*Filter[T], error) {
+	typ := reflect.TypeOf((*T)(nil)).Elem()
+	if typ.Kind() != reflect.Struct {
+		return nil, fmt.Errorf("compile for %v: %w", typ, ErrTypeNotMatched)
+	}
+	var conf ParseConfig
+	if cfg != nil {
+		conf = *cfg
+	} else {
+		conf = defaultConfig
+	}
+	conf.Cache = nil
+	conf.FieldResolver = nil
+	conf.pathResolver = func(path []PathSegment) (func(any) (any, error), error) {
+		return structPath[T](typ, path)
+	}
+	cond, err := Parse(query, &conf)
+	if err != nil {
+		return nil, err
+	}
+	return &Filter[T]{cond: cond}, nil
+}
+
+// Match tells if rec matches the filter.
+func (f *Filter[T]) Match(rec *T) (bool, error) {
+	return f.cond.IsTrue(NewContext(rec))
+}
+
+// MatchWith is Match using ctx, whose Env is set to rec. The results memoized
+// by ctx are reset.
+func (f *Filter[T]) MatchWith(ctx *Context, rec *T) (bool, error) {
+	ctx.Env = rec
+	ctx.Reset()
+	return f.cond.IsTrue(ctx)
+}
+
+// Cond returns the compiled condition, it takes a *T as the env.
+func (f *Filter[T]) Cond() BoolAst {
+	return f.cond
+}
+
+// pathStep is a step of a path in a record: the struct field at index, the
+// element of a pointer if deref is set, or else the element at of a slice or an
+// array.
+type pathStep struct {
+	index []int
+	deref bool
+	at    int
+}
+
+// structPath returns an accessor of path in the struct type T. The path goes
+// through struct fields, pointers and indexes of slices and arrays, and ends at
+// a type read by fieldReader. A nil pointer or an index out of range on the
+// way gives null.
+func structPath[T any](typ reflect.Type, path []PathSegment) (func(any) (any, error), error) {
+	var steps []pathStep
+	t := typ
+	for i, seg := range path {
+		if i > 0 {
+			for t.Kind() == reflect.Pointer {
+				steps = append(steps, pathStep{deref: true})
+				t = t.Elem()
+			}
+		}
+		switch {
+		case t.Kind() == reflect.Struct && !seg.IsIndex:
+			sf, found := t.FieldByName(seg.Name)
+			if !found || !sf.IsExported() {
+				return nil, ErrNoSuchField
+			}
+			steps = append(steps, pathStep{index: sf.Index})
+			t = sf.Type
+		case t.Kind() == reflect.Slice && seg.IsIndex && seg.Index >= 0,
+			t.Kind() == reflect.Array && seg.IsIndex && seg.Index >= 0 && seg.Index < t.Len():
+			steps = append(steps, pathStep{at: seg.Index})
+			t = t.Elem()
+		case t.Kind() == reflect.Map || t.Kind() == reflect.Interface:
+			return nil, ErrUnsupportedField
+		default:
+			return nil, ErrNoSuchField
+		}
+	}
+	for t.Kind() == reflect.Pointer {
+		steps = append(steps, pathStep{deref: true})
+		t = t.Elem()
+	}
+	read := fieldReader(t)
+	if read == nil {
+		return nil, ErrUnsupportedField
+	}
+	return func(env any) (any, error) {
+		rec, is := env.(*T)
+		if !is || rec == nil {
+			return nil, ErrTypeNotMatched
+		}
+		v := reflect.ValueOf(rec).Elem()
+		for _, s := range steps {
+			switch {
+			case s.index != nil:
+				var err error
+				// a nil embedded pointer on the way
+				if v, err = v.FieldByIndexErr(s.index); err != nil {
+					return nil, nil
+				}
+			case s.deref:
+				if v.IsNil() {
+					return nil, nil
+				}
+				v = v.Elem()
+			case s.at >= v.Len():
+				return nil, nil
+			default:
+				v = v.Index(s.at)
+			}
+		}
+		// the values reached from a pointer are all addressable
+		return read(unsafe.Pointer(v.UnsafeAddr())), nil
+	}, nil
+}
+
+// fieldReader returns a function reading a value of typ at a pointer, or nil
+// if typ can't be read without reflection. The values of named types are read
+// as their underlying basic types.
+func fieldReader(typ reflect.Type) func(unsafe.Pointer) any {
+	switch typ.Kind() {
+	case reflect.Int:
+		return func(p unsafe.Pointer) any { return *(*int)(p) }
+	case reflect.Int8:
+		return func(p unsafe.Pointer) any { return *(*int8)(p) }
+	case reflect.Int16:
+		return func(p unsafe.Pointer) any { return *(*int16)(p) }
+	case reflect.Int32:
+		return func(p unsafe.Pointer) any { return *(*int32)(p) }
+	case reflect.Int64:
+		return func(p unsafe.Pointer) any { return *(*int64)(p) }
+	case reflect.Uint:
+		return func(p unsafe.Pointer) any { return *(*uint)(p) }
+	case reflect.Uint8:
+		return func(p unsafe.Pointer) any { return *(*uint8)(p) }
+	case reflect.Uint16:
+		return func(p unsafe.Pointer) any { return *(*uint16)(p) }
+	case reflect.Uint32:
+		return func(p unsafe.Pointer) any { return *(*uint32)(p) }
+	case reflect.Uint64:
+		return func(p unsafe.Pointer) any { return *(*uint64)(p) }
+	case reflect.Float32:
+		return func(p unsafe.Pointer) any { return float64(*(*float32)(p)) }
+	case reflect.Float64:
+		return func(p unsafe.Pointer) any { return *(*float64)(p) }
+	case reflect.String:
+		return func(p unsafe.Pointer) any { return *(*string)(p) }
+	case reflect.Bool:
+		return func(p unsafe.Pointer) any { return *(*bool)(p) }
+	case reflect.Slice:
+		switch typ.Elem().Kind() {
+		case reflect.String:
+			if typ.Elem() == reflect.TypeOf("") {
+				return func(p unsafe.Pointer) any { return *(*[]string)(p) }
+			}
+		case reflect.Int:
+			if typ.Elem() == reflect.TypeOf(0) {
+				return func(p unsafe.Pointer) any { return *(*[]int)(p) }
+			}
+		}
+	case reflect.Interface:
+		if typ.NumMethod() == 0 {
+			return func(p unsafe.Pointer) any { return *(*any)(p) }
+		}
+	}
+	return nil
+}
@@ -0,0 +1,132 @@
+package filterql_test
+
+import (
+	"errors"
+	"testing"
+
+	fql "github.com/lennon-guan/filterql"
+)
+
+type Audit struct {
+	Active  bool
+	Created int64
+}
+
+type Owner struct {
+	Team string
+}
+
+type Item struct {
+	Record
+	*Owner
+	Audit
+	Tags   []string
+	Weight float32
+	Rank   uint8
+	Parts  []Owner
+	Grid   [2]int
+	Bonus  *int
+	Extra  any
+	Meta   map[string]string
+	note   string
+}
+
+func testCompile(t *testing.T, items []Item, query string, expectedIds ...int) {
+	t.Helper()
+	filter, err := fql.Compile[Item](query, cfg)
+	if err != nil {
+		t.Errorf("compile %s error %+v", query, err)
+		return
+	}
+	ids := []int{}
+	for i := range items {
+		if matched, err := filter.Match(&items[i]); err != nil {
+			t.Errorf("%s match %d error %+v", query, items[i].ID, err)
+		} else if matched {
+			ids = append(ids, items[i].ID)
+		}
+	}
+	if want, got := joinInts(expectedIds), joinInts(ids); want != got {
+		t.Errorf("%s want %s got %s", query, want, got)
+	}
+}
+
+func TestCompile(t *testing.T) {
+	items := make([]Item, len(records))
+	for i, rec := range records {
+		items[i] = Item{
+			Record: rec,
+			Audit:  Audit{Active: rec.Level > 8, Created: int64(rec.ID) * 1000},
+			Tags:   []string{rec.Name, "fruit"},
+			Weight: float32(rec.ID) / 2,
+			Rank:   uint8(rec.Source),
+		}
+		if rec.Source == 1 {
+			items[i].Owner = &Owner{Team: "red"}
+		}
+		for j := 0; j < rec.ID%3; j++ {
+			items[i].Parts = append(items[i].Parts, Owner{Team: rec.Name})
+		}
+		items[i].Grid[rec.ID%2] = rec.Level
+		if rec.Level > 8 {
+			items[i].Bonus = &items[i].Level
+			items[i].Extra = rec.Name
+		}
+	}
+	testCompile(t, items, "Level >= 8 and Source < 4", 1, 3, 4, 5)
+	testCompile(t, items, "Active", 1, 5, 7)
+	testCompile(t, items, "not Active and Score > 3", 3, 6)
+	testCompile(t, items, "Created between 2000 and 4000 and Weight < 2", 2, 3)
+	testCompile(t, items, "Rank in (3, 4) or Name like 'a%'", 6, 7)
+	testCompile(t, items, "'Egg' in Tags", 5)
+	testCompile(t, items, "Team is not null and Team = 'red'", 1, 2, 3)
+	testCompile(t, items, "Team is null", 4, 5, 6, 7)
+	testCompile(t, items, "Owner.Team is null or Owner.Team = 'red'", 1, 2, 3, 4, 5, 6, 7)
+	testCompile(t, items, "Parts[1].Team is not null or Parts[0].Team is null", 2, 3, 5, 6)
+	testCompile(t, items, "Parts[1].Team is not null and Parts[1].Team like '%an%'", 2)
+	testCompile(t, items, "Grid[1] > 8 and Grid[0] = 0", 1, 5, 7)
+	testCompile(t, items, "Bonus is not null and Bonus > 10", 5, 7)
+	testCompile(t, items, "Extra is not null and Extra like '%e'", 1, 7)
+	testCompile(t, items, "Bonus is null and Extra is null", 2, 3, 4, 6)
+	testCompile(t, items, "Tags[0] like '%an%' and Tags[1] = 'fruit' and Tags[2] is null", 2)
+	testCompile(t, items, "Audit.Active and Record.Source = 1", 1)
+	testCompile(t, items, "double(ID) = ID + ID and Level * 1 = Level", 1, 2, 3, 4, 5, 6, 7)
+
+	for query, pos := range map[string]int{
+		"Nosuch = 1":             0,
+		"Level > 1 and note = 1": 14,
+		"rec(Missing) = 1":       4,
+		"Missing.Team = 1":       0,
+		"Team.Name = 1":          0,
+		"Grid[2] = 1":            0,
+		"Tags.Name = 1":          0,
+	} {
+		if _, err := fql.Compile[Item](query, cfg); !errors.Is(err, fql.ErrNoSuchField) {
+			t.Errorf("compile %s expected no such field but got %+v", query, err)
+		} else if pe, is := err.(*fql.ParseError); !is || pe.Pos != pos {
+			t.Errorf("compile %s got error %+v", query, err)
+		}
+	}
+	for _, query := range []string{"Owner is null", "Audit = 1", "Parts[0] is null", "Meta['a'] = 'b'", "Extra.Name = 1"} {
+		if _, err := fql.Compile[Item](query, cfg); !errors.Is(err, fql.ErrUnsupportedField) {
+			t.Errorf("compile %s expected unsupported field but got %+v", query, err)
+		}
+	}
+	if _, err := fql.Compile[int]("Level > 1", cfg); !errors.Is(err, fql.ErrTypeNotMatched) {
+		t.Errorf("expected type not matched but got %+v", err)
+	}
+}
+
+func BenchmarkCompiledFilter(b *testing.B) {
+	filter, err := fql.Compile[Record]("Source = 1 and Level > 7", nil)
+	if err != nil {
+		b.Fatalf("compile error %+v", err)
+	}
+	n := len(records)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		for j := 0; j < 100; j++ {
+			filter.Match(&records[j%n])
+		}
+	}
+}
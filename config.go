@@ -1,14 +1,89 @@
 package filterql
 
+import "context"
+
+// Method is a method taking any number of arguments. The arguments of a call
+// are checked against Params when the query is parsed. If Variadic is set, the
+// last param can be repeated any number of times, including zero. CtxFn is
+// called instead of Fn if it's set, with the Ctx of the evaluation Context.
+// Returns is the type of the result, which is checked against its uses when
+// the query is parsed unless it's TypeAny. NonDeterministic keeps the results
+// from being memoized, see Context.Memoize.
+type Method struct {
+	Params           []Type
+	Variadic         bool
+	Returns          Type
+	NonDeterministic bool
+	Fn               func(env any, args []any) (any, error)
+	CtxFn            func(ctx context.Context, env any, args []any) (any, error)
+}
+
+// paramType returns the type of the i-th argument
+func (m *Method) paramType(i int) Type {
+	if n := len(m.Params); i < n {
+		return m.Params[i]
+	} else if n > 0 {
+		return m.Params[n-1]
+	}
+	return TypeAny
+}
+
 type ParseConfig struct {
-	StrMethods       map[string]func(any, string) (any, error)
-	IntMethods       map[string]func(any, int) (any, error)
-	Cache            CacheProvider
-	DefaultIntMethod func(string, any, int) (any, error)
-	DefaultStrMethod func(string, any, string) (any, error)
+	Methods      map[string]Method
+	StrMethods   map[string]func(any, string) (any, error)
+	IntMethods   map[string]func(any, int) (any, error)
+	FloatMethods map[string]func(any, float64) (any, error)
+	// ReturnTypes are the result types of the methods in the maps above and
+	// the default methods, by method name. A missing one is TypeAny.
+	ReturnTypes map[string]Type
+	// The context-aware methods take precedence over the ones of the same
+	// name and argument type above.
+	CtxStrMethods   map[string]func(context.Context, any, string) (any, error)
+	CtxIntMethods   map[string]func(context.Context, any, int) (any, error)
+	CtxFloatMethods map[string]func(context.Context, any, float64) (any, error)
+	Cache           CacheProvider
+	// FieldResolver binds a bare name in the query, like Level in Level > 5,
+	// to a function getting the field from the env. An error from it is
+	// reported as a ParseError at the name. For a path like user.tags[0] it's
+	// only asked for the first name. Without it, the whole path is looked up
+	// in the env when the query is evaluated.
+	FieldResolver      func(name string) (func(env any) (any, error), error)
+	DefaultIntMethod   func(string, any, int) (any, error)
+	DefaultStrMethod   func(string, any, string) (any, error)
+	DefaultFloatMethod func(string, any, float64) (any, error)
+	// NonDeterministic are the names of the methods in the maps above and of
+	// the default methods whose results are not to be memoized, like
+	// Method.NonDeterministic.
+	NonDeterministic map[string]bool
+	// Optimize makes Parse simplify the filters with Optimize, or with
+	// OptimizeAssumeValid if AssumeValid is set too.
+	Optimize    bool
+	AssumeValid bool
+	// pathResolver binds a whole field path, it's set by Compile and takes
+	// precedence over FieldResolver.
+	pathResolver func(path []PathSegment) (func(env any) (any, error), error)
 }
 
 var defaultConfig = ParseConfig{
-	StrMethods: map[string]func(any, string) (any, error){},
-	IntMethods: map[string]func(any, int) (any, error){},
+	StrMethods:   map[string]func(any, string) (any, error){},
+	IntMethods:   map[string]func(any, int) (any, error){},
+	FloatMethods: map[string]func(any, float64) (any, error){},
+}
+
+func (cfg *ParseConfig) hasStrMethod(name string) bool {
+	_, has := cfg.StrMethods[name]
+	_, hasCtx := cfg.CtxStrMethods[name]
+	return has || hasCtx
+}
+
+func (cfg *ParseConfig) hasIntMethod(name string) bool {
+	_, has := cfg.IntMethods[name]
+	_, hasCtx := cfg.CtxIntMethods[name]
+	return has || hasCtx
+}
+
+func (cfg *ParseConfig) hasFloatMethod(name string) bool {
+	_, has := cfg.FloatMethods[name]
+	_, hasCtx := cfg.CtxFloatMethods[name]
+	return has || hasCtx
 }
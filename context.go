@@ -1,10 +1,134 @@
 package filterql
 
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"strings"
+)
+
+// Context carries the env of one evaluation. The parsed condition keeps no
+// state while it's evaluated, so it can be shared by many goroutines as long
+// as each of them uses its own Context.
 type Context struct {
-	Env    any
-	result any
+	Env any
+	// Ctx is passed to the context-aware methods. Once it's done, the
+	// evaluation stops before the next method call or the next child of an
+	// AND or OR, and IsTrue returns an error matching ErrCanceled.
+	Ctx context.Context
+	// ThreeValued turns on SQL-style three-valued logic: a comparison against
+	// a null method result is unknown rather than ErrTypeNotMatched, and
+	// unknown propagates through AND, OR and NOT. IsTrue reports an unknown
+	// result as ErrUnknown.
+	ThreeValued bool
+	// Memoize makes a method called with the same arguments again return the
+	// result of the first call, without calling it. The results are dropped
+	// when Env is set to another value, or by Reset. A map or slice env
+	// changed in place is the same value, so Reset is to be called then. The
+	// methods declared non-deterministic are always called, and so are the
+	// ones which failed.
+	Memoize bool
+	memo    map[memoKey]any
+	// memoEnv is the env the results in memo are of
+	memoEnv any
 }
 
 func NewContext(env any) *Context {
 	return &Context{Env: env}
 }
+
+// Reset forgets the memoized results of the methods.
+func (ctx *Context) Reset() {
+	ctx.memo, ctx.memoEnv = nil, nil
+}
+
+// memoKey is a method and its arguments. The arguments of a Method are kept
+// as a methodArgs.
+type memoKey struct {
+	name string
+	arg  any
+}
+
+type methodArgs string
+
+// memoKeyOf returns the key of a call, or false if the arguments are not all
+// literal values.
+func memoKeyOf(name string, arg any) (memoKey, bool) {
+	args, is := arg.([]any)
+	if !is {
+		return memoKey{name: name, arg: arg}, true
+	}
+	var b strings.Builder
+	for _, v := range args {
+		switch v.(type) {
+		case int, uint64, float64, string, bool, nil:
+			// the type tells 1 from 1.0
+			fmt.Fprintf(&b, "%T %s,", v, literalString(v))
+		default:
+			return memoKey{}, false
+		}
+	}
+	return memoKey{name: name, arg: methodArgs(b.String())}, true
+}
+
+// memoized returns the result memoized for key, the results of another env
+// are dropped first.
+func (ctx *Context) memoized(key memoKey) (any, bool) {
+	if ctx.memo != nil && !sameEnv(ctx.memoEnv, ctx.Env) {
+		ctx.Reset()
+	}
+	ret, found := ctx.memo[key]
+	return ret, found
+}
+
+func (ctx *Context) memoize(key memoKey, ret any) {
+	if ctx.memo == nil {
+		ctx.memo, ctx.memoEnv = map[memoKey]any{}, ctx.Env
+	}
+	ctx.memo[key] = ret
+}
+
+// sameEnv tells if a and b are the same env. The maps and slices, which can't
+// be compared, are the same if they share their storage.
+func sameEnv(a, b any) bool {
+	ta, tb := reflect.TypeOf(a), reflect.TypeOf(b)
+	if ta != tb {
+		return false
+	} else if ta == nil || ta.Comparable() {
+		return a == b
+	}
+	va, vb := reflect.ValueOf(a), reflect.ValueOf(b)
+	switch ta.Kind() {
+	case reflect.Map:
+		return va.Pointer() == vb.Pointer()
+	case reflect.Slice:
+		return va.Pointer() == vb.Pointer() && va.Len() == vb.Len()
+	}
+	return false
+}
+
+// nullError is what a comparison against a null value results in.
+func (ctx *Context) nullError() error {
+	if ctx.ThreeValued {
+		return ErrUnknown
+	}
+	return ErrTypeNotMatched
+}
+
+// canceled returns a canceled error if Ctx is done.
+func (ctx *Context) canceled() error {
+	if ctx.Ctx == nil {
+		return nil
+	} else if err := ctx.Ctx.Err(); err != nil {
+		return &canceledError{cause: err}
+	}
+	return nil
+}
+
+// goContext returns Ctx, or the background context if it's not set.
+func (ctx *Context) goContext() context.Context {
+	if ctx.Ctx == nil {
+		return context.Background()
+	}
+	return ctx.Ctx
+}
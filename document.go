@@ -0,0 +1,168 @@
+package filterql
+
+import (
+	"bytes"
+	"encoding/json"
+	"math"
+	"strings"
+	"unicode/utf8"
+)
+
+// Document is an env made of named fields, like a JSON object. The field
+// paths in a query are looked up by Lookup instead of reflection. Lookup
+// returns nil and false if the path is missing.
+type Document interface {
+	Lookup(path []PathSegment) (any, bool)
+}
+
+// MapDocument is a JSON object decoded into a map. The numbers found in it
+// are normalized: integral ones are returned as int and the others as
+// float64, so they compare with both int and float literals.
+type MapDocument map[string]any
+
+func (d MapDocument) Lookup(path []PathSegment) (any, bool) {
+	v, found := lookupPath(map[string]any(d), path)
+	return normalizeJSON(v), found
+}
+
+// RawDocument is an encoded JSON object. Each lookup scans the bytes up to
+// the value at the path and only decodes that value, so a query touching a
+// few fields doesn't unmarshal the whole document. The numbers are
+// normalized as in MapDocument.
+type RawDocument []byte
+
+func (d RawDocument) Lookup(path []PathSegment) (any, bool) {
+	dec := json.NewDecoder(bytes.NewReader(d))
+	dec.UseNumber()
+	for _, seg := range path {
+		if !seekSegment(dec, seg) {
+			return nil, false
+		}
+	}
+	var v any
+	if err := dec.Decode(&v); err != nil {
+		return nil, false
+	}
+	return normalizeJSON(v), true
+}
+
+// seekSegment moves dec to the value at seg in the current object or array.
+func seekSegment(dec *json.Decoder, seg PathSegment) bool {
+	tok, err := dec.Token()
+	if err != nil {
+		return false
+	}
+	switch tok {
+	case json.Delim('{'):
+		if seg.IsIndex {
+			return false
+		}
+		for dec.More() {
+			key, err := dec.Token()
+			if err != nil {
+				return false
+			} else if key == seg.Name {
+				return true
+			} else if skipValue(dec) != nil {
+				return false
+			}
+		}
+	case json.Delim('['):
+		if !seg.IsIndex || seg.Index < 0 {
+			return false
+		}
+		for i := 0; dec.More(); i++ {
+			if i == seg.Index {
+				return true
+			} else if skipValue(dec) != nil {
+				return false
+			}
+		}
+	}
+	return false
+}
+
+func skipValue(dec *json.Decoder) error {
+	var raw json.RawMessage
+	return dec.Decode(&raw)
+}
+
+// normalizeJSON turns the numbers decoded from JSON into int if they are
+// integral, or float64 otherwise. The elements of an array are normalized
+// too, the ones of an object when they are looked up.
+func normalizeJSON(v any) any {
+	switch n := v.(type) {
+	case float64:
+		if n == math.Trunc(n) && n >= math.MinInt && n < math.MaxInt {
+			return int(n)
+		}
+	case json.Number:
+		if i, err := n.Int64(); err == nil && i >= math.MinInt && i <= math.MaxInt {
+			return int(i)
+		} else if f, err := n.Float64(); err == nil {
+			return normalizeJSON(f)
+		}
+		return n.String()
+	case []any:
+		list := make([]any, len(n))
+		for i, e := range n {
+			list[i] = normalizeJSON(e)
+		}
+		return list
+	}
+	return v
+}
+
+// NewDocumentConfig returns a ParseConfig for Document envs such as
+// MapDocument and RawDocument. Fields are written as paths in the query, and
+// it has the following methods:
+//
+//	field('a.b[0]')  the value at the path given as a string, or null
+//	exists('a.b')    if the path is present, even with a null value
+//	len(a.b)         the length of a string, array or object
+func NewDocumentConfig() *ParseConfig {
+	return &ParseConfig{
+		StrMethods: map[string]func(any, string) (any, error){
+			"field": func(env any, path string) (any, error) {
+				v, _, err := lookupText(env, path)
+				return v, err
+			},
+			"exists": func(env any, path string) (any, error) {
+				_, found, err := lookupText(env, path)
+				return found, err
+			},
+		},
+		ReturnTypes: map[string]Type{"exists": TypeBool},
+		Methods: map[string]Method{
+			"len": {
+				Params:  []Type{TypeAny},
+				Returns: TypeInt,
+				Fn: func(_ any, args []any) (any, error) {
+					switch v := args[0].(type) {
+					case string:
+						return utf8.RuneCountInString(v), nil
+					case []any:
+						return len(v), nil
+					case map[string]any:
+						return len(v), nil
+					}
+					return nil, ErrTypeNotMatched
+				},
+			},
+		},
+	}
+}
+
+// lookupText looks up a path given as text in env.
+func lookupText(env any, text string) (any, bool, error) {
+	path, err := parsePath(strings.TrimSpace(text))
+	if err != nil {
+		return nil, false, err
+	}
+	if doc, is := env.(Document); is {
+		v, found := doc.Lookup(path)
+		return v, found, nil
+	}
+	v, found := lookupPath(env, path)
+	return v, found, nil
+}
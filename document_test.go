@@ -0,0 +1,82 @@
+package filterql_test
+
+import (
+	"encoding/json"
+	"testing"
+
+	fql "github.com/lennon-guan/filterql"
+)
+
+var docs = []string{
+	`{"id": 1, "name": "ann", "age": 30, "score": 4.5, "tags": ["vip", "beta"], "address": {"city": "NYC", "zip": "10001"}, "active": true}`,
+	`{"id": 2, "name": "bob", "age": 41, "score": 3, "tags": [], "address": {"city": "LA"}, "active": false, "note": null}`,
+	`{"id": 3, "name": "cid", "age": 25.5, "tags": ["beta"], "sizes": [1, 2.5, 3]}`,
+}
+
+func testDocuments(t *testing.T, query string, expectedIds ...int) {
+	t.Helper()
+	cond, err := fql.Parse(query, fql.NewDocumentConfig())
+	if err != nil {
+		t.Errorf("parse %s error %+v", query, err)
+		return
+	}
+	mapIds, rawIds := []int{}, []int{}
+	for i, doc := range docs {
+		var m map[string]any
+		if err := json.Unmarshal([]byte(doc), &m); err != nil {
+			t.Fatalf("bad document %d %+v", i, err)
+		}
+		for _, env := range []any{fql.MapDocument(m), fql.RawDocument(doc)} {
+			ctx := fql.NewContext(env)
+			ctx.ThreeValued = true
+			matched, err := cond.IsTrue(ctx)
+			if err != nil && err != fql.ErrUnknown {
+				t.Errorf("%s on %T %d error %+v", query, env, i, err)
+			} else if _, is := env.(fql.MapDocument); is && matched {
+				mapIds = append(mapIds, i+1)
+			} else if matched {
+				rawIds = append(rawIds, i+1)
+			}
+		}
+	}
+	want := joinInts(expectedIds)
+	if got := joinInts(mapIds); got != want {
+		t.Errorf("%s on map want %s got %s", query, want, got)
+	}
+	if got := joinInts(rawIds); got != want {
+		t.Errorf("%s on raw want %s got %s", query, want, got)
+	}
+}
+
+func TestDocument(t *testing.T) {
+	testDocuments(t, "id = 1 or age > 40", 1, 2)
+	testDocuments(t, "age in (25, 30, 41) and id % 2 = 1", 1)
+	testDocuments(t, "age between 25 and 26", 3)
+	testDocuments(t, "score >= 3 and score < 4.5", 2)
+	testDocuments(t, "'beta' in tags", 1, 3)
+	testDocuments(t, "tags[0] = 'vip' or sizes[1] = 2.5", 1, 3)
+	testDocuments(t, "sizes[2] * 2 = 6 and sizes[0] in (1)", 3)
+	testDocuments(t, "address.city like '%A' and address.zip is null", 2)
+	testDocuments(t, "active", 1)
+	testDocuments(t, "not active", 2)
+	testDocuments(t, "field('address.city') = 'NYC' and field('tags[1]') = 'beta'", 1)
+	testDocuments(t, "exists('note') and note is null", 2)
+	testDocuments(t, "not exists('address')", 3)
+	testDocuments(t, "len(tags) = 0 or len(name) > 3 or len(address) = 2", 1, 2)
+	testDocuments(t, "missing.deep[3] is null and tags['x'] is null and address[0] is null", 1, 2, 3)
+}
+
+func TestRawDocumentLazy(t *testing.T) {
+	// the broken tail is never scanned
+	doc := fql.RawDocument(`{"id": 7, "name": "lazy", "rest": [1, 2, `)
+	cond, err := fql.Parse("id = 7 and name = 'lazy'", nil)
+	if err != nil {
+		t.Fatalf("parse error %+v", err)
+	}
+	if matched, err := cond.IsTrue(fql.NewContext(doc)); err != nil || !matched {
+		t.Errorf("expected matched but got %v %+v", matched, err)
+	}
+	if v, found := doc.Lookup([]fql.PathSegment{{Name: "rest"}}); found || v != nil {
+		t.Errorf("expected broken value not found but got %v", v)
+	}
+}
@@ -3,8 +3,37 @@ package filterql
 import "errors"
 
 var (
-	ErrUnexpectedEnd   = errors.New("unexpected end")
-	ErrUnexpectedToken = errors.New("unexpected token")
-	ErrTypeNotMatched  = errors.New("type not match")
-	ErrNoSuchMethod    = errors.New("no such method")
+	ErrUnexpectedEnd      = errors.New("unexpected end")
+	ErrUnexpectedToken    = errors.New("unexpected token")
+	ErrTypeNotMatched     = errors.New("type not match")
+	ErrNoSuchMethod       = errors.New("no such method")
+	ErrNumberOutOfRange   = errors.New("number out of range")
+	ErrUnknown            = errors.New("unknown result")
+	ErrWrongArgCount      = errors.New("wrong number of arguments")
+	ErrDivisionByZero     = errors.New("division by zero")
+	ErrCanceled           = errors.New("evaluation canceled")
+	ErrNoSuchField        = errors.New("no such field")
+	ErrUnsupportedField   = errors.New("unsupported field type")
+	ErrInvalidFilter      = errors.New("invalid filter")
+	ErrUnsupportedVersion = errors.New("unsupported filter version")
+	ErrTooComplex         = errors.New("filter too complex")
 )
+
+// canceledError is returned when the evaluation is stopped by Context.Ctx. It
+// matches ErrCanceled and unwraps to the error of the context, so
+// context.DeadlineExceeded can be told apart from context.Canceled.
+type canceledError struct {
+	cause error
+}
+
+func (e *canceledError) Error() string {
+	return ErrCanceled.Error() + ": " + e.cause.Error()
+}
+
+func (e *canceledError) Is(target error) bool {
+	return target == ErrCanceled
+}
+
+func (e *canceledError) Unwrap() error {
+	return e.cause
+}
@@ -0,0 +1,425 @@
+package filterql
+
+import (
+	"fmt"
+	"io"
+	"strings"
+)
+
+// Trace is the evaluation of a node by Explain. Its children are the traces
+// of the children of the node, in the same order.
+type Trace struct {
+	Node PrintableAst
+	// IsCond is set if the node is evaluated as a condition, or else it's an
+	// operand.
+	IsCond bool
+	// Method is the method called by the node with Args, if any.
+	Method string
+	Args   []any
+	// Value is the result of an operand, or of the method called by a
+	// condition on it.
+	Value any
+	// Compare is the comparison done by a condition, like 3 = 1.
+	Compare string
+	// Result is the outcome of a condition. Err is the error of the node,
+	// which is ErrUnknown for an unknown outcome.
+	Result bool
+	Err    error
+	// Skipped is set for the children of AND and OR after the one deciding
+	// the outcome. They are not evaluated and have no children.
+	Skipped  bool
+	Children []*Trace
+}
+
+// Explain evaluates cond as IsTrue does and returns the trace of it, whose
+// Result and Err are what IsTrue returns. The methods are called the same
+// way, so they should not be called twice for the same env.
+func Explain(cond BoolAst, ctx *Context) *Trace {
+	return explainCond(ctx, cond)
+}
+
+// Explain is Match returning the trace of it.
+func (f *Filter[T]) Explain(rec *T) *Trace {
+	return Explain(f.cond, NewContext(rec))
+}
+
+type condExplainer interface {
+	explain(ctx *Context) *Trace
+}
+
+type evalExplainer interface {
+	explainEval(ctx *Context) *Trace
+}
+
+// explainCond traces cond, only the outcome of a node of another package is
+// known.
+func explainCond(ctx *Context, cond BoolAst) *Trace {
+	if e, is := cond.(condExplainer); is {
+		return e.explain(ctx)
+	}
+	t := &Trace{Node: cond, IsCond: true}
+	t.Result, t.Err = cond.IsTrue(ctx)
+	return t
+}
+
+func explainEval(ctx *Context, node EvalAst) *Trace {
+	if e, is := node.(evalExplainer); is {
+		return e.explainEval(ctx)
+	}
+	t := &Trace{Node: node}
+	t.Value, t.Err = node.Eval(ctx)
+	return t
+}
+
+// explainOperands traces the operands in order, until one of them fails.
+func explainOperands(ctx *Context, t *Trace, operands ...EvalAst) []any {
+	values := make([]any, len(operands))
+	for i, operand := range operands {
+		child := explainEval(ctx, operand)
+		t.Children = append(t.Children, child)
+		if child.Err != nil {
+			t.Err = child.Err
+			return nil
+		}
+		values[i] = child.Value
+	}
+	return values
+}
+
+func (a *ANDs) explain(ctx *Context) *Trace {
+	return explainJunction(ctx, a, a.Children, true)
+}
+
+func (a *ORs) explain(ctx *Context) *Trace {
+	return explainJunction(ctx, a, a.Children, false)
+}
+
+// explainJunction traces an AND if and is set, or else an OR.
+func explainJunction(ctx *Context, node BoolAst, children []BoolAst, and bool) *Trace {
+	t := &Trace{Node: node, IsCond: true}
+	unknown, decided := false, false
+	for _, child := range children {
+		if !decided && t.Err == nil {
+			t.Err = ctx.canceled()
+		}
+		if decided || t.Err != nil {
+			t.Children = append(t.Children, &Trace{Node: child, IsCond: true, Skipped: true})
+			continue
+		}
+		ct := explainCond(ctx, child)
+		t.Children = append(t.Children, ct)
+		if ct.Err == ErrUnknown {
+			unknown = true
+		} else if ct.Err != nil {
+			t.Err = ct.Err
+		} else if ct.Result != and {
+			decided = true
+		}
+	}
+	if t.Err == nil {
+		if !decided && unknown {
+			t.Err = ErrUnknown
+		} else {
+			t.Result = decided != and
+		}
+	}
+	return t
+}
+
+func (a *NOT) explain(ctx *Context) *Trace {
+	t := &Trace{Node: a, IsCond: true}
+	child := explainCond(ctx, a.Child)
+	t.Children = []*Trace{child}
+	if t.Err = child.Err; t.Err == nil {
+		t.Result = !child.Result
+	}
+	return t
+}
+
+func (c *call[T]) explainEval(ctx *Context) *Trace {
+	t := &Trace{Node: c}
+	arg := c.arg
+	if c.argCall != nil {
+		values := explainOperands(ctx, t, c.argCall)
+		if t.Err != nil {
+			return t
+		} else if arg, t.Err = c.argOf(ctx, values[0]); t.Err != nil {
+			return t
+		}
+	}
+	t.Method, t.Args = c.name, []any{arg}
+	t.Value, t.Err = c.invoke(ctx, arg)
+	return t
+}
+
+func (c *call[T]) explain(ctx *Context) *Trace {
+	return explainTruth(ctx, c.explainEval(ctx), c.not)
+}
+
+// explainTruth turns the trace of an operand used as a condition into the
+// trace of the condition.
+func explainTruth(ctx *Context, t *Trace, not bool) *Trace {
+	t.IsCond = true
+	if t.Err == nil {
+		t.Result, t.Err = truthOf(ctx, t.Value, not)
+	}
+	return t
+}
+
+func (c *funcCall) explainEval(ctx *Context) *Trace {
+	t := &Trace{Node: c}
+	args := c.consts
+	if args == nil {
+		values := explainOperands(ctx, t, c.args...)
+		if t.Err != nil {
+			return t
+		}
+		args = make([]any, len(values))
+		for i, v := range values {
+			if args[i], t.Err = c.argOf(ctx, i, v); t.Err != nil {
+				return t
+			}
+		}
+	}
+	t.Method, t.Args = c.name, args
+	t.Value, t.Err = c.invoke(ctx, args)
+	return t
+}
+
+func (c *funcCall) explain(ctx *Context) *Trace {
+	return explainTruth(ctx, c.explainEval(ctx), c.not)
+}
+
+func (l *Literal) explainEval(ctx *Context) *Trace {
+	return &Trace{Node: l, Value: l.Value}
+}
+
+func (l *Literal) explain(ctx *Context) *Trace {
+	return &Trace{Node: l, IsCond: true, Value: l.Value, Result: isTruthy(l.Value)}
+}
+
+func (a *Arith) explainEval(ctx *Context) *Trace {
+	t := &Trace{Node: a}
+	if values := explainOperands(ctx, t, a.Left, a.Right); t.Err == nil {
+		t.Value, t.Err = a.apply(values[0], values[1])
+	}
+	return t
+}
+
+func (a *Arith) explain(ctx *Context) *Trace {
+	return explainTruth(ctx, a.explainEval(ctx), a.not)
+}
+
+func (f *Field) explainEval(ctx *Context) *Trace {
+	t := &Trace{Node: f}
+	t.Value, t.Err = f.Eval(ctx)
+	return t
+}
+
+func (f *Field) explain(ctx *Context) *Trace {
+	return explainTruth(ctx, f.explainEval(ctx), f.not)
+}
+
+func (c *IsNull) explain(ctx *Context) *Trace {
+	t := &Trace{Node: c, IsCond: true}
+	if values := explainOperands(ctx, t, c.Call); t.Err == nil {
+		if c.NotNull {
+			t.Compare = valueString(values[0]) + " is not null"
+		} else {
+			t.Compare = valueString(values[0]) + " is null"
+		}
+		t.Result = isNull(values[0]) != c.NotNull
+	}
+	return t
+}
+
+func (c *Compare[T]) explain(ctx *Context) *Trace {
+	t := &Trace{Node: c, IsCond: true}
+	if values := explainOperands(ctx, t, c.Call); t.Err == nil {
+		t.Compare = compareString(values[0], opString(c.Op), c.Target)
+		t.Result, t.Err = c.test(ctx, values[0])
+	}
+	return t
+}
+
+func (c *StrMatch) explain(ctx *Context) *Trace {
+	t := &Trace{Node: c, IsCond: true}
+	if values := explainOperands(ctx, t, c.Call); t.Err == nil {
+		op := opString(c.Op)
+		if c.NotMatch {
+			op = notOpString(c.Op)
+		}
+		t.Compare = compareString(values[0], op, c.Pattern)
+		t.Result, t.Err = c.test(ctx, values[0])
+	}
+	return t
+}
+
+func (c *Between) explain(ctx *Context) *Trace {
+	t := &Trace{Node: c, IsCond: true}
+	if values := explainOperands(ctx, t, c.Call, c.Lower, c.Upper); t.Err == nil {
+		op := "between"
+		if c.NotBetween {
+			op = "not between"
+		}
+		t.Compare = fmt.Sprintf("%s %s %s and %s",
+			valueString(values[0]), op, valueString(values[1]), valueString(values[2]))
+		t.Result, t.Err = c.test(ctx, values[0], values[1], values[2])
+	}
+	return t
+}
+
+func (c *In[T]) explain(ctx *Context) *Trace {
+	t := &Trace{Node: c, IsCond: true}
+	if values := explainOperands(ctx, t, c.Call); t.Err == nil {
+		t.Compare = valueString(values[0]) + " " + inOpString(c.NotIn) + " " + listString(c.Choices)
+		t.Result, t.Err = c.test(ctx, values[0])
+	}
+	return t
+}
+
+func (c *CompareWithCall) explain(ctx *Context) *Trace {
+	t := &Trace{Node: c, IsCond: true}
+	if values := explainOperands(ctx, t, c.Left, c.Right); t.Err == nil {
+		t.Compare = compareString(values[0], opString(c.Op), values[1])
+		t.Result, t.Err = c.test(ctx, values[0], values[1])
+	}
+	return t
+}
+
+func (c *InWithCall) explain(ctx *Context) *Trace {
+	t := &Trace{Node: c, IsCond: true}
+	if values := explainOperands(ctx, t, c.Left, c.Right); t.Err == nil {
+		t.Compare = compareString(values[0], inOpString(c.NotIn), values[1])
+		t.Result, t.Err = c.test(ctx, values[0], values[1])
+	}
+	return t
+}
+
+func (c *callThenCompare[T1, T2]) explain(ctx *Context) *Trace {
+	t := &Trace{Node: c, IsCond: true, Method: c.name, Args: []any{c.arg}}
+	if t.Value, t.Err = c.invoke(ctx, c.arg); t.Err == nil {
+		t.Compare = compareString(t.Value, opString(c.op), c.target)
+		t.Result, t.Err = c.test(ctx, t.Value)
+	}
+	return t
+}
+
+func (c *callThenIn[T1, T2]) explain(ctx *Context) *Trace {
+	t := &Trace{Node: c, IsCond: true, Method: c.name, Args: []any{c.arg}}
+	if t.Value, t.Err = c.invoke(ctx, c.arg); t.Err == nil {
+		t.Compare = valueString(t.Value) + " " + inOpString(c.not) + " " + listString(c.choices)
+		t.Result, t.Err = c.test(ctx, t.Value)
+	}
+	return t
+}
+
+func notOpString(op int) string {
+	switch op {
+	case TOKEN_OP_MATCH:
+		return "!~"
+	case TOKEN_OP_NOT_MATCH:
+		return "~"
+	}
+	return "not " + opString(op)
+}
+
+func inOpString(not bool) string {
+	if not {
+		return "not in"
+	}
+	return "in"
+}
+
+func compareString(v any, op string, target any) string {
+	return valueString(v) + " " + op + " " + valueString(target)
+}
+
+// valueString returns the source of a literal value, or the default format
+// of the other values.
+func valueString(v any) string {
+	switch v.(type) {
+	case int, uint64, float64, string, bool, nil:
+		return literalString(v)
+	}
+	return fmt.Sprintf("%v", v)
+}
+
+// isOperand tells if node is an operand other than a literal, which has a
+// value of its own when it's used as a condition.
+func isOperand(node PrintableAst) bool {
+	if _, is := node.(*Literal); is {
+		return false
+	}
+	_, is := node.(EvalAst)
+	return is
+}
+
+// outcome returns the annotation of the line of t.
+func (t *Trace) outcome() string {
+	var s string
+	switch {
+	case t.Skipped:
+		return "skipped"
+	case t.Err == ErrUnknown:
+		s = "unknown"
+	case t.Err != nil:
+		return "error: " + t.Err.Error()
+	case t.IsCond:
+		s = fmt.Sprint(t.Result)
+		if isOperand(t.Node) && t.Compare == "" {
+			s += " (" + valueString(t.Value) + ")"
+		}
+	default:
+		return valueString(t.Value)
+	}
+	if t.Compare != "" {
+		s += " (" + t.Compare + ")"
+	}
+	return s
+}
+
+// PrintTo writes the trace in the layout of PrintTo of the nodes, with the
+// outcome of each node at the end of its line. The method call of a
+// condition calling one itself gets a line of its own, and the literal
+// operands get none.
+func (t *Trace) PrintTo(level int, out io.Writer) {
+	indent := strings.Repeat("  ", level)
+	var name string
+	switch t.Node.(type) {
+	case *ANDs:
+		name = "AND"
+	case *ORs:
+		name = "OR"
+	case *NOT:
+		name = "NOT"
+	}
+	if name != "" && !t.Skipped {
+		fmt.Fprintf(out, "%s%s ( => %s\n", indent, name, t.outcome())
+		for _, child := range t.Children {
+			child.PrintTo(level+1, out)
+		}
+		fmt.Fprintf(out, "%s)\n", indent)
+		return
+	}
+	fmt.Fprintf(out, "%s%s => %s\n", indent, t.Node, t.outcome())
+	if t.Method != "" && !isOperand(t.Node) && t.Compare != "" {
+		args := make([]string, len(t.Args))
+		for i, arg := range t.Args {
+			args[i] = valueString(arg)
+		}
+		fmt.Fprintf(out, "%s  %s(%s) => %s\n", indent, t.Method, strings.Join(args, ", "), valueString(t.Value))
+	}
+	for _, child := range t.Children {
+		if _, is := child.Node.(*Literal); !is || child.IsCond {
+			child.PrintTo(level+1, out)
+		}
+	}
+}
+
+// String returns the trace as written by PrintTo.
+func (t *Trace) String() string {
+	var b strings.Builder
+	t.PrintTo(0, &b)
+	return b.String()
+}
@@ -0,0 +1,139 @@
+package filterql_test
+
+import (
+	"testing"
+
+	fql "github.com/lennon-guan/filterql"
+)
+
+func TestExplain(t *testing.T) {
+	for _, query := range []string{
+		"rec('Source') = 1 or rec('Source') = 3 or rec('Source') in arg('sources')",
+		"rec('Level') > 7 and not rec('Name') like '%e%'",
+		"rec('Level') * 2 + rec('ID') > 25 and Score between 3 and arg('min_score')",
+		"bucket('Level', 3) in (1, 2) or lower(rec('Name')) starts with 'b'",
+		"rec('Score') > rec('Level') or Name ~ arg('name_pattern')",
+		"opt('Level') is null and rec('Level') not in (5, 11)",
+		"opt('Level') > 7 or contains('Name', 'an')",
+		"rec('Name') in arg('sources')",
+		"double(rec('ID')) >= 8",
+	} {
+		cond, err := fql.Parse(query, cfg)
+		if err != nil {
+			t.Errorf("parse %s error %+v", query, err)
+			continue
+		}
+		for i := range records {
+			m, err := cond.IsTrue(fql.NewContext(&records[i]))
+			tr := fql.Explain(cond, fql.NewContext(&records[i]))
+			if tr.Result != m || tr.Err != err && (tr.Err == nil || err == nil || tr.Err.Error() != err.Error()) {
+				t.Errorf("%s on record %d got %v %v but IsTrue got %v %v", query, i, tr.Result, tr.Err, m, err)
+			}
+		}
+	}
+}
+
+func TestExplainTrace(t *testing.T) {
+	cond, err := fql.Parse("rec('Source') = 1 or rec('Source') = 3 or rec('Source') in arg('sources')", cfg)
+	if err != nil {
+		t.Fatalf("parse error %+v", err)
+	}
+	tr := fql.Explain(cond, fql.NewContext(&records[5]))
+	if !tr.Result || len(tr.Children) != 3 {
+		t.Fatalf("unexpected trace %+v", tr)
+	}
+	first, second, third := tr.Children[0], tr.Children[1], tr.Children[2]
+	if first.Method != "rec" || first.Args[0] != "Source" || first.Value != 3 || first.Result {
+		t.Errorf("unexpected first trace %+v", first)
+	}
+	if !second.Result || second.Compare != "3 = 3" || second.Skipped {
+		t.Errorf("unexpected second trace %+v", second)
+	}
+	if !third.Skipped || len(third.Children) != 0 {
+		t.Errorf("expected the third skipped but got %+v", third)
+	}
+	expected := `OR ( => true
+  rec('Source') = 1 => false (3 = 1)
+    rec('Source') => 3
+  rec('Source') = 3 => true (3 = 3)
+    rec('Source') => 3
+  rec('Source') in arg('sources') => skipped
+)
+`
+	if s := tr.String(); s != expected {
+		t.Errorf("expected\n%s\nbut got\n%s", expected, s)
+	}
+
+	cond, err = fql.Parse("rec('Level') * 2 > 15 and not (Name like 'E%' or opt('Level'))", cfg)
+	if err != nil {
+		t.Fatalf("parse error %+v", err)
+	}
+	expected = `AND ( => false
+  rec('Level') * 2 > 15 => true (20 > 15)
+    rec('Level') * 2 => 20
+      rec('Level') => 10
+  Name not like 'E%' => true ('Apple' not like 'E%')
+    Name => 'Apple'
+  not opt('Level') => false (10)
+)
+`
+	if s := fql.Explain(cond, fql.NewContext(&records[0])).String(); s != expected {
+		t.Errorf("expected\n%s\nbut got\n%s", expected, s)
+	}
+}
+
+func TestExplainError(t *testing.T) {
+	cond, err := fql.Parse("rec('Level') > 5 and arg('bad') = 1 and rec('ID') = 1", cfg)
+	if err != nil {
+		t.Fatalf("parse error %+v", err)
+	}
+	tr := fql.Explain(cond, fql.NewContext(&records[0]))
+	if tr.Err == nil || tr.Children[1].Err == nil || !tr.Children[2].Skipped {
+		t.Errorf("unexpected trace\n%s", tr)
+	}
+	ctx := fql.NewContext(&records[3])
+	ctx.ThreeValued = true
+	cond, err = fql.Parse("opt('Level') > 5 or rec('ID') = 2", cfg)
+	if err != nil {
+		t.Fatalf("parse error %+v", err)
+	}
+	expected := `OR ( => unknown
+  opt('Level') > 5 => unknown (null > 5)
+    opt('Level') => null
+  rec('ID') = 2 => false (4 = 2)
+    rec('ID') => 4
+)
+`
+	if s := fql.Explain(cond, ctx).String(); s != expected {
+		t.Errorf("expected\n%s\nbut got\n%s", expected, s)
+	}
+}
+
+func TestExplainIsNull(t *testing.T) {
+	for query, expected := range map[string]string{
+		"opt('Level') is null":     "opt('Level') is null => false (10 is null)\n  opt('Level') => 10\n",
+		"opt('Level') is not null": "opt('Level') is not null => true (10 is not null)\n  opt('Level') => 10\n",
+	} {
+		cond, err := fql.Parse(query, cfg)
+		if err != nil {
+			t.Errorf("parse %s error %+v", query, err)
+			continue
+		}
+		if s := fql.Explain(cond, fql.NewContext(&records[0])).String(); s != expected {
+			t.Errorf("expected\n%s\nbut got\n%s", expected, s)
+		}
+	}
+}
+
+func TestFilterExplain(t *testing.T) {
+	f, err := fql.Compile[Record]("Level > 8 and Name like '%e'", cfg)
+	if err != nil {
+		t.Fatalf("compile error %+v", err)
+	}
+	for i := range records {
+		m, err := f.Match(&records[i])
+		if tr := f.Explain(&records[i]); tr.Result != m || tr.Err != err {
+			t.Errorf("record %d got %v %v but Match got %v %v", i, tr.Result, tr.Err, m, err)
+		}
+	}
+}
@@ -1,12 +1,15 @@
 package filterql_test
 
 import (
+	"context"
 	"errors"
 	"fmt"
+	"math"
 	"os"
 	"reflect"
 	"strings"
 	"testing"
+	"time"
 
 	fql "github.com/lennon-guan/filterql"
 )
@@ -16,19 +19,65 @@ type Record struct {
 	Name   string
 	Source int
 	Level  int
+	Score  float64
 }
 
 var (
 	records = []Record{
-		{ID: 1, Name: "Apple", Source: 1, Level: 10},
-		{ID: 2, Name: "Banana", Source: 1, Level: 6},
-		{ID: 3, Name: "Cherry", Source: 1, Level: 8},
-		{ID: 4, Name: "DragonFruit", Source: 2, Level: 8},
-		{ID: 5, Name: "Egg", Source: 2, Level: 20},
-		{ID: 6, Name: "Fig", Source: 3, Level: 5},
-		{ID: 7, Name: "Grape", Source: 4, Level: 11},
+		{ID: 1, Name: "Apple", Source: 1, Level: 10, Score: 4.5},
+		{ID: 2, Name: "Banana", Source: 1, Level: 6, Score: 3},
+		{ID: 3, Name: "Cherry", Source: 1, Level: 8, Score: 4.8},
+		{ID: 4, Name: "DragonFruit", Source: 2, Level: 8, Score: 2.5},
+		{ID: 5, Name: "Egg", Source: 2, Level: 20, Score: 3.9},
+		{ID: 6, Name: "Fig", Source: 3, Level: 5, Score: 4},
+		{ID: 7, Name: "Grape", Source: 4, Level: 11, Score: 4.75},
 	}
 	cfg = &fql.ParseConfig{
+		Methods: map[string]fql.Method{
+			"one": {
+				Fn: func(env any, args []any) (any, error) { return 1, nil },
+			},
+			"bucket": {
+				Params: []fql.Type{fql.TypeStr, fql.TypeInt},
+				Fn: func(env any, args []any) (any, error) {
+					n := reflect.ValueOf(env).Elem().FieldByName(args[0].(string)).Int()
+					return int(n) % args[1].(int), nil
+				},
+			},
+			"scaled": {
+				Params: []fql.Type{fql.TypeStr, fql.TypeFloat},
+				Fn: func(env any, args []any) (any, error) {
+					n := reflect.ValueOf(env).Elem().FieldByName(args[0].(string)).Int()
+					return float64(n) * args[1].(float64), nil
+				},
+			},
+			"len": {
+				Params: []fql.Type{fql.TypeAny},
+				Fn: func(env any, args []any) (any, error) {
+					return reflect.ValueOf(args[0]).Len(), nil
+				},
+			},
+			"contains": {
+				Params: []fql.Type{fql.TypeStr, fql.TypeStr},
+				Fn: func(env any, args []any) (any, error) {
+					v := reflect.ValueOf(env).Elem().FieldByName(args[0].(string)).String()
+					return strings.Contains(v, args[1].(string)), nil
+				},
+			},
+			"any_of": {
+				Params:   []fql.Type{fql.TypeStr, fql.TypeAny},
+				Variadic: true,
+				Fn: func(env any, args []any) (any, error) {
+					v := reflect.ValueOf(env).Elem().FieldByName(args[0].(string)).Interface()
+					for _, arg := range args[1:] {
+						if v == arg {
+							return true, nil
+						}
+					}
+					return false, nil
+				},
+			},
+		},
 		StrMethods: map[string]func(any, string) (any, error){
 			"rec": func(env any, field string) (any, error) {
 				rec := env.(*Record)
@@ -41,6 +90,14 @@ var (
 					return rec.Source, nil
 				case "Level":
 					return rec.Level, nil
+				case "Score":
+					return rec.Score, nil
+				case "Level64":
+					return int64(rec.Level), nil
+				case "Level32":
+					return int32(rec.Level), nil
+				case "LevelU":
+					return uint(rec.Level), nil
 				}
 				return reflect.ValueOf(env).Elem().FieldByName(field).Interface(), nil
 			},
@@ -50,10 +107,29 @@ var (
 					return 5, nil
 				case "sources":
 					return []int{1, 3}, nil
+				case "max_uint":
+					return uint64(math.MaxUint64), nil
+				case "name_pattern":
+					return "^(Egg|Fig)$", nil
+				case "bad_pattern":
+					return "(", nil
+				case "min_score":
+					return 4.5, nil
+				case "levels":
+					return []float64{8, 10.0, 20}, nil
 				default:
 					return nil, errors.New("unknown arg " + field)
 				}
 			},
+			"lower": func(env any, s string) (any, error) {
+				return strings.ToLower(s), nil
+			},
+			"opt": func(env any, field string) (any, error) {
+				if rec := env.(*Record); rec.Source != 1 {
+					return nil, nil
+				}
+				return reflect.ValueOf(env).Elem().FieldByName(field).Interface(), nil
+			},
 			"env": func(env any, field string) (any, error) {
 				switch field {
 				case "one_or_three":
@@ -64,6 +140,16 @@ var (
 				}
 			},
 		},
+		IntMethods: map[string]func(any, int) (any, error){
+			"double": func(env any, n int) (any, error) {
+				return n * 2, nil
+			},
+		},
+		FloatMethods: map[string]func(any, float64) (any, error){
+			"level_div": func(env any, d float64) (any, error) {
+				return float64(env.(*Record).Level) / d, nil
+			},
+		},
 	}
 )
 
@@ -189,6 +275,557 @@ func TestNotCallResultCheck(t *testing.T) {
 	testFilter(t, "not env('one_or_three')", 4, 5, 7)
 }
 
+func TestFloat(t *testing.T) {
+	testFilter(t, "rec('Score') >= 4.5", 1, 3, 7)
+	testFilter(t, "rec('Score') = 3", 2)
+	testFilter(t, "rec('Level') > 9.5", 1, 5, 7)
+	testFilter(t, "rec('Score') in (3, 4.75, 4)", 2, 6, 7)
+	testFilter(t, "rec('Level') in (5.0, 6.5, 8)", 3, 4, 6)
+	testFilter(t, "rec('Score') >= arg('min_score')", 1, 3, 7)
+	testFilter(t, "rec('Level') in arg('levels')", 1, 3, 4, 5)
+}
+
+func TestFloatMethod(t *testing.T) {
+	testFilter(t, "level_div(2.5) >= 4", 1, 5, 7)
+	testFilter(t, "level_div(2) = 4", 3, 4)
+}
+
+func TestSignedInt(t *testing.T) {
+	testFilter(t, "rec('Level') > -5", 1, 2, 3, 4, 5, 6, 7)
+	testFilter(t, "rec('Level') in (-1, +5, 6)", 2, 6)
+	testFilter(t, "rec('Score') > -2.5e0 and rec('Level') <= +5", 6)
+}
+
+func TestSizedInt(t *testing.T) {
+	testFilter(t, "rec('Level64') in (-1, 8, 20)", 3, 4, 5)
+	testFilter(t, "rec('Level32') >= 10", 1, 5, 7)
+	testFilter(t, "rec('LevelU') < 6", 6)
+	testFilter(t, "rec('LevelU') = rec('Level64')", 1, 2, 3, 4, 5, 6, 7)
+	testFilter(t, "arg('max_uint') = 18446744073709551615", 1, 2, 3, 4, 5, 6, 7)
+	testFilter(t, "arg('max_uint') > 9223372036854775807", 1, 2, 3, 4, 5, 6, 7)
+	testFilter(t, "rec('Level') < 18446744073709551615", 1, 2, 3, 4, 5, 6, 7)
+	testFilter(t, "rec('Level64') in (-9223372036854775808, 20)", 5)
+}
+
+func TestIntOverflow(t *testing.T) {
+	for query, pos := range map[string]int{
+		"rec('Level') > 18446744073709551616":        15,
+		"rec('Level') in (1, -9223372036854775809)":  20,
+		"rec('Level') in (-1, 18446744073709551615)": 21,
+	} {
+		_, err := fql.Parse(query, cfg)
+		if pe, is := err.(*fql.ParseError); !is {
+			t.Errorf("parse %s expected ParseError but got %+v", query, err)
+		} else if pe.Pos != pos || !errors.Is(pe.Err, fql.ErrNumberOutOfRange) {
+			t.Errorf("parse %s got error %+v", query, pe)
+		}
+	}
+}
+
+func TestBoolLiteral(t *testing.T) {
+	testFilter(t, "env('one_or_three') = true", 1, 2, 3, 6)
+	testFilter(t, "env('one_or_three') <> TRUE", 4, 5, 7)
+	testFilter(t, "not env('one_or_three') = false", 1, 2, 3, 6)
+	testFilter(t, "true and rec('ID') < 3", 1, 2)
+	testFilter(t, "false or rec('ID') = 2", 2)
+	if _, err := fql.Parse("env('one_or_three') > true", cfg); err == nil {
+		t.Error("bools should only be compared for equality")
+	}
+}
+
+func TestIsNull(t *testing.T) {
+	testFilter(t, "opt('Level') is null", 4, 5, 6, 7)
+	testFilter(t, "opt('Level') IS NOT NULL", 1, 2, 3)
+	testFilter(t, "not opt('Level') is null", 1, 2, 3)
+	testFilter(t, "opt('Level') is null or opt('Level') > 6", 1, 3, 4, 5, 6, 7)
+}
+
+func testThreeValued(t *testing.T, query string, expectedIds []int, unknownIds []int) {
+	t.Logf("query: %s", query)
+	cond, err := fql.Parse(query, cfg)
+	if err != nil {
+		t.Errorf("parse query [%s] error %+v", query, err)
+		return
+	}
+	ids, unknowns := []int{}, []int{}
+	ctx := fql.NewContext(nil)
+	ctx.ThreeValued = true
+	for i := range records {
+		ctx.Env = &records[i]
+		if matched, err := cond.IsTrue(ctx); err == fql.ErrUnknown {
+			unknowns = append(unknowns, records[i].ID)
+		} else if err != nil {
+			t.Errorf("filter record %d error %+v", i, err)
+		} else if matched {
+			ids = append(ids, records[i].ID)
+		}
+	}
+	if want, got := joinInts(expectedIds), joinInts(ids); want != got {
+		t.Errorf("filter result wrong. want %s got %s", want, got)
+	}
+	if want, got := joinInts(unknownIds), joinInts(unknowns); want != got {
+		t.Errorf("unknown result wrong. want %s got %s", want, got)
+	}
+}
+
+func TestThreeValued(t *testing.T) {
+	testThreeValued(t, "opt('Level') > 6", []int{1, 3}, []int{4, 5, 6, 7})
+	testThreeValued(t, "opt('Level') > 6 or rec('Source') = 2", []int{1, 3, 4, 5}, []int{6, 7})
+	testThreeValued(t, "opt('Level') > 6 and rec('Source') = 2", []int{}, []int{4, 5})
+	testThreeValued(t, "not (opt('Level') > 6 and rec('Source') = 3)", []int{1, 2, 3, 4, 5, 7}, []int{6})
+	testThreeValued(t, "opt('Name') in ('Apple', 'Fig')", []int{1}, []int{4, 5, 6, 7})
+	testThreeValued(t, "opt('Name')", []int{1, 2, 3}, []int{4, 5, 6, 7})
+
+	cond, _ := fql.Parse("opt('Level') > 6", cfg)
+	if _, err := cond.IsTrue(fql.NewContext(&records[3])); err != fql.ErrTypeNotMatched {
+		t.Errorf("expected ErrTypeNotMatched without three-valued logic but got %+v", err)
+	}
+}
+
+func TestMultiArgCall(t *testing.T) {
+	testFilter(t, "rec('Source') = one()", 1, 2, 3)
+	testFilter(t, "bucket('ID', 2) = 1", 1, 3, 5, 7)
+	testFilter(t, "bucket('Level', 4) in (1, 2)", 1, 2, 6)
+	testFilter(t, "scaled('Level', 2) > 21.5", 5, 7)
+	testFilter(t, "any_of('Name', 'Egg', 'Fig', 'Kiwi')", 5, 6)
+	testFilter(t, "any_of('Source', 3, 4) or any_of('ID')", 6, 7)
+	testFilter(t, "not any_of('Source', 1)", 4, 5, 6, 7)
+}
+
+func TestNestedCall(t *testing.T) {
+	testFilter(t, "lower(rec('Name')) = 'apple'", 1)
+	testFilter(t, "len(rec('Name')) > 5", 2, 3, 4)
+	testFilter(t, "len(lower(rec('Name'))) in (3)", 5, 6)
+	testFilter(t, "double(rec('Level')) > 20", 5, 7)
+	testFilter(t, "double(rec('Level64')) = 16", 3, 4)
+	testFilter(t, "level_div(rec('Source')) >= 5", 1, 2, 3, 5)
+	testFilter(t, "bucket('ID', rec('Source')) = 0", 1, 2, 3, 4, 6)
+
+	cond, _ := fql.Parse("lower(rec('ID')) = '1'", cfg)
+	if _, err := cond.IsTrue(fql.NewContext(&records[0])); err != fql.ErrTypeNotMatched {
+		t.Errorf("expected ErrTypeNotMatched but got %+v", err)
+	}
+}
+
+func TestArith(t *testing.T) {
+	testFilter(t, "rec('Level') * 2 + 1 > 21", 5, 7)
+	testFilter(t, "rec('Level') - rec('Source') < 5", 6)
+	testFilter(t, "(rec('Level') + 2) * 2 = 20", 3, 4)
+	testFilter(t, "rec('Level') % 3 = 2", 3, 4, 5, 6, 7)
+	testFilter(t, "rec('Level') / 4 = 2", 1, 3, 4, 7)
+	testFilter(t, "rec('Level') / 4.0 = 2", 3, 4)
+	testFilter(t, "rec('Score') * 2 >= 9", 1, 3, 7)
+	testFilter(t, "rec('Level64') + rec('LevelU') = 40", 5)
+	testFilter(t, "rec('Name') + '!' = 'Egg!'", 5)
+	testFilter(t, "rec('ID')-1 = 0", 1)
+	testFilter(t, "2 * 3 + 1 = 7 and 2 * (3 + 1) = 8 and 1 < 2", 1, 2, 3, 4, 5, 6, 7)
+	testFilter(t, "20 - rec('Level') - 5 >= 10", 6)
+	testFilter(t, "(rec('ID') = 1 or rec('ID') = 2) and rec('Level') > 6", 1)
+	testFilter(t, "((rec('ID') + 1)) * 2 = 4 or (rec('ID')) = 7", 1, 7)
+	testFilter(t, "opt('Level') + 1 is null", 4, 5, 6, 7)
+	testFilter(t, "9223372036854775806 + rec('ID') / rec('ID') > 0 and -9223372036854775807 - rec('ID') / rec('ID') < 0"+
+		" and -4611686018427387904 * (rec('ID') / rec('ID') + 1) < 0", 1, 2, 3, 4, 5, 6, 7)
+
+	for query, expected := range map[string]error{
+		"rec('Level') / (rec('ID') - 1) > 0":                       fql.ErrDivisionByZero,
+		"rec('Level') % 0.0 > 0":                                   fql.ErrDivisionByZero,
+		"rec('Name') * 2 = 1":                                      fql.ErrTypeNotMatched,
+		"9223372036854775807 + rec('ID') > 0":                      fql.ErrNumberOutOfRange,
+		"-9223372036854775807 - rec('ID') - 1 < 0":                 fql.ErrNumberOutOfRange,
+		"4611686018427387904 * (rec('ID') + 1) > 0":                fql.ErrNumberOutOfRange,
+		"(-9223372036854775807 - rec('ID')) * (0 - rec('ID')) > 0": fql.ErrNumberOutOfRange,
+		"(-9223372036854775807 - rec('ID')) / (0 - rec('ID')) > 0": fql.ErrNumberOutOfRange,
+	} {
+		cond, err := fql.Parse(query, cfg)
+		if err != nil {
+			t.Errorf("parse query [%s] error %+v", query, err)
+		} else if _, err := cond.IsTrue(fql.NewContext(&records[0])); err != expected {
+			t.Errorf("query [%s] expected %+v but got %+v", query, expected, err)
+		}
+	}
+}
+
+func TestStrMatch(t *testing.T) {
+	testFilter(t, "rec('Name') like 'D%'", 4)
+	testFilter(t, "rec('Name') LIKE '_g%'", 5)
+	testFilter(t, "rec('Name') like '%an%a'", 2)
+	testFilter(t, "rec('Name') ilike 'a%'", 1)
+	testFilter(t, "rec('Name') not like '%e%'", 2, 4, 5, 6)
+	testFilter(t, "rec('Name') + '%' like 'Egg\\\\%'", 5)
+	testFilter(t, "rec('Name') + '_x' like '%\\\\_y'")
+	testFilter(t, "rec('Name') glob '*a?e'", 7)
+	testFilter(t, "rec('Name') glob '?i*'", 6)
+	testFilter(t, "rec('Name') starts with 'Ch'", 3)
+	testFilter(t, "rec('Name') ends with 'it'", 4)
+	testFilter(t, "rec('Name') contains 'an'", 2)
+	testFilter(t, "rec('Name') not contains 'a'", 1, 3, 5, 6)
+	testFilter(t, "not rec('Name') starts with 'A'", 2, 3, 4, 5, 6, 7)
+	testFilter(t, "rec('Name') not ends with 'e'", 2, 3, 4, 5, 6)
+	testFilter(t, "contains('Name', 'an') and rec('Name') contains 'an'", 2)
+
+	cond, _ := fql.Parse("rec('ID') like '1'", cfg)
+	if _, err := cond.IsTrue(fql.NewContext(&records[0])); err != fql.ErrTypeNotMatched {
+		t.Errorf("expected ErrTypeNotMatched but got %+v", err)
+	}
+}
+
+func TestRegexpMatch(t *testing.T) {
+	testFilter(t, "rec('Name') ~ '^[A-C]'", 1, 2, 3)
+	testFilter(t, "rec('Name') !~ 'a'", 1, 3, 5, 6)
+	testFilter(t, "not rec('Name') ~ 'rr'", 1, 2, 4, 5, 6, 7)
+	testFilter(t, "rec('Name') ~ arg('name_pattern')", 5, 6)
+	testFilter(t, "rec('Name') !~ arg('name_pattern')", 1, 2, 3, 4, 7)
+	testFilter(t, "not rec('Name') ~ arg('name_pattern')", 1, 2, 3, 4, 7)
+
+	for query, pos := range map[string]int{
+		"rec('Name') ~ '(a'":  14,
+		"rec('Name') !~ 5":    15,
+		"rec('Name') ~ 'a[x'": 14,
+	} {
+		_, err := fql.Parse(query, cfg)
+		if pe, is := err.(*fql.ParseError); !is || pe.Pos != pos {
+			t.Errorf("parse %s expected ParseError at %d but got %+v", query, pos, err)
+		}
+	}
+	cond, _ := fql.Parse("rec('Name') ~ arg('bad_pattern')", cfg)
+	if _, err := cond.IsTrue(fql.NewContext(&records[0])); err == nil {
+		t.Error("expected an error for the invalid pattern")
+	}
+}
+
+func TestBetween(t *testing.T) {
+	testFilter(t, "rec('Level') between 8 and 11", 1, 3, 4, 7)
+	testFilter(t, "rec('Level') BETWEEN 8 AND 11 EXCLUSIVE", 1)
+	testFilter(t, "rec('Level') not between 8 and 11", 2, 5, 6)
+	testFilter(t, "not rec('Level') between 8 and 11 exclusive", 2, 3, 4, 5, 6, 7)
+	testFilter(t, "rec('Level') between 8 and 11 and rec('Source') = 1", 1, 3)
+	testFilter(t, "rec('Level') between rec('ID') + 5 and 10 * 2 or rec('ID') = 2", 1, 2, 3, 5)
+	testFilter(t, "rec('Score') between 4 and 4.75", 1, 6, 7)
+	testFilter(t, "rec('Name') between 'B' and 'E'", 2, 3, 4)
+
+	calls := 0
+	conf := &fql.ParseConfig{
+		StrMethods: map[string]func(any, string) (any, error){
+			"level": func(env any, _ string) (any, error) {
+				calls++
+				return env.(*Record).Level, nil
+			},
+		},
+	}
+	cond, err := fql.Parse("level('') between 5 and 10", conf)
+	if err != nil {
+		t.Fatalf("parse error %+v", err)
+	}
+	if matched, err := cond.IsTrue(fql.NewContext(&records[0])); err != nil || !matched {
+		t.Errorf("expected matched but got %v %+v", matched, err)
+	}
+	if calls != 1 {
+		t.Errorf("expected the method called once but got %d", calls)
+	}
+}
+
+func TestCallArgCheck(t *testing.T) {
+	for query, expected := range map[string]struct {
+		err error
+		pos int
+	}{
+		"bucket('ID') = 1":         {fql.ErrWrongArgCount, 0},
+		"one(1) = 1":               {fql.ErrWrongArgCount, 0},
+		"bucket('ID', 'x') = 1":    {fql.ErrTypeNotMatched, 13},
+		"bucket('ID', 2.5) = 1":    {fql.ErrTypeNotMatched, 13},
+		"rec('ID') = nosuch(1, 2)": {fql.ErrNoSuchMethod, 12},
+	} {
+		_, err := fql.Parse(query, cfg)
+		if pe, is := err.(*fql.ParseError); !is {
+			t.Errorf("parse %s expected ParseError but got %+v", query, err)
+		} else if pe.Pos != expected.pos || !errors.Is(err, expected.err) {
+			t.Errorf("parse %s got error %+v", query, pe)
+		}
+	}
+}
+
+func TestTypeCheck(t *testing.T) {
+	str := func(any, string) (any, error) { return "", nil }
+	num := func(any, int) (any, error) { return 0, nil }
+	conf := &fql.ParseConfig{
+		StrMethods:  map[string]func(any, string) (any, error){"name": str, "any": str},
+		IntMethods:  map[string]func(any, int) (any, error){"level": num},
+		ReturnTypes: map[string]fql.Type{"name": fql.TypeStr, "level": fql.TypeInt},
+		Methods: map[string]fql.Method{
+			"tags":  {Returns: fql.TypeList},
+			"score": {Returns: fql.TypeFloat},
+			"flag":  {Returns: fql.TypeBool},
+			"upper": {Params: []fql.Type{fql.TypeStr}, Returns: fql.TypeStr},
+			"half":  {Params: []fql.Type{fql.TypeFloat}, Returns: fql.TypeFloat},
+		},
+	}
+	for _, query := range []string{
+		"level(1) = 2.5 and score() in (1, 2) and 'a' in tags()",
+		"name('') + 'x' = 'ax' or level(1) * score() > 1",
+		"flag() = true and not flag() and any('') > 1 and any('') in any('')",
+		"upper(name('')) like 'A%' and half(level(1)) between 1 and score()",
+		"name('') ~ any('') and level(1) in (1, 2.5)",
+	} {
+		if _, err := fql.Parse(query, conf); err != nil {
+			t.Errorf("parse %s error %+v", query, err)
+		}
+	}
+	for query, pos := range map[string]int{
+		"level(1) = 'abc'":             11,
+		"name('') > 5":                 11,
+		"level(1) in ('a', 'b')":       13,
+		"name('') not in (1, 2.5)":     17,
+		"level(1) in name('')":         12,
+		"tags() = 1":                   9,
+		"level(1) like 'a%'":           9,
+		"score() not ilike 'a%'":       12,
+		"flag() > true":                9,
+		"flag() <= any('')":            10,
+		"name('') + 1 > 0":             11,
+		"level(1) between 'a' and 'z'": 17,
+		"level(1) between 0 and 'z'":   23,
+		"upper(level(1)) = 'A'":        6,
+		"half(name('')) = 1":           5,
+		"score() ~ 'x'":                10,
+		"any('') !~ level(1)":          11,
+	} {
+		_, err := fql.Parse(query, conf)
+		if pe, is := err.(*fql.ParseError); !is || pe.Pos != pos || !errors.Is(err, fql.ErrTypeNotMatched) {
+			t.Errorf("parse %s expected type error at %d but got %+v", query, pos, err)
+		}
+	}
+}
+
+func TestString(t *testing.T) {
+	for query, expected := range map[string]string{
+		"rec('Source')=1":                                                     "rec('Source') = 1",
+		"rec('Source') in (1,3) AND rec('ID')<>2":                             "rec('Source') in (1, 3) and rec('ID') <> 2",
+		"(rec('ID') = 1 or rec('ID') = 2) and (rec('Level') > 5)":             "(rec('ID') = 1 or rec('ID') = 2) and rec('Level') > 5",
+		"rec('ID') = 1 or (rec('ID') = 2 and rec('Level') > 5)":               "rec('ID') = 1 or rec('ID') = 2 and rec('Level') > 5",
+		"not (rec('ID') = 1 or rec('ID') = 2)":                                "rec('ID') <> 1 and rec('ID') <> 2",
+		"not rec('ID') in (1, 2)":                                             "rec('ID') not in (1, 2)",
+		"rec('Score') > 4 and rec('Score') in (3, 4.5)":                       "rec('Score') > 4 and rec('Score') in (3.0, 4.5)",
+		"level_div(3) > 2.5 and rec('Level') >= -1":                           "level_div(3.0) > 2.5 and rec('Level') >= -1",
+		"rec('Name') = 'it\\'s\\t\\\\'":                                       "rec('Name') = 'it\\'s\\t\\\\'",
+		"(rec('Level') - 2) * 2 + -1 > rec('ID') - (1 - rec('ID'))":           "(rec('Level') - 2) * 2 + -1 > rec('ID') - (1 - rec('ID'))",
+		"rec('Level') / (2 * 2) % 3 = 0":                                      "rec('Level') / (2 * 2) % 3 = 0",
+		"rec('Source') in arg('sources') or rec('Level') = double(rec('ID'))": "rec('Source') in arg('sources') or rec('Level') = double(rec('ID'))",
+		"opt('Name') is not null and not opt('Name') is null":                 "opt('Name') is not null and opt('Name') is not null",
+		"rec('Name') not like 'A%' and rec('Name') starts with 'B'":           "rec('Name') not like 'A%' and rec('Name') starts with 'B'",
+		"rec('Name') ~ '^[AB]' or rec('Name') !~ 'e$'":                        "rec('Name') ~ '^[AB]' or rec('Name') !~ 'e$'",
+		"rec('Level') not between 8 and 11 exclusive":                         "rec('Level') not between 8 and 11 exclusive",
+		"bucket('ID', 3) = one() or env('one_or_three') = true":               "bucket('ID', 3) = one() or env('one_or_three') = true",
+		"not env('one_or_three') and true":                                    "not env('one_or_three') and true",
+		"Level > 8 or Name = 'Fig'":                                           "Level > 8 or Name = 'Fig'",
+	} {
+		cond, err := fql.Parse(query, cfg)
+		if err != nil {
+			t.Errorf("parse %s error %+v", query, err)
+			continue
+		}
+		s := cond.String()
+		if s != expected {
+			t.Errorf("%s got %s", query, s)
+		}
+		again, err := fql.Parse(s, cfg)
+		if err != nil {
+			t.Errorf("parse %s again error %+v", s, err)
+			continue
+		} else if again.String() != s {
+			t.Errorf("%s got %s after parsed again", s, again.String())
+		}
+		for i := range records {
+			ctx := fql.NewContext(&records[i])
+			m1, err1 := cond.IsTrue(ctx)
+			m2, err2 := again.IsTrue(ctx)
+			if m1 != m2 || fmt.Sprint(err1) != fmt.Sprint(err2) {
+				t.Errorf("%s on record %d got %v %v and %v %v", s, i, m1, err1, m2, err2)
+			}
+		}
+	}
+}
+
+func TestStringRoundTrip(t *testing.T) {
+	name := &fql.Field{Path: []fql.PathSegment{{Name: "Name"}}}
+	for expected, cond := range map[string]fql.BoolAst{
+		"['first name'].x is null": &fql.IsNull{Call: &fql.Field{Path: []fql.PathSegment{{Name: "first name"}, {Name: "x"}}}},
+		"['like'] = 'a'":           &fql.CompareWithCall{Left: &fql.Field{Path: []fql.PathSegment{{Name: "like"}}}, Op: fql.TOKEN_OP_EQ, Right: &fql.Literal{Value: "a"}},
+		"a.like['b c']":            &fql.Field{Path: []fql.PathSegment{{Name: "a"}, {Name: "like"}, {Name: "b c"}}},
+		"['true']":                 &fql.Field{Path: []fql.PathSegment{{Name: "true"}}},
+		"null is null":             &fql.IsNull{Call: &fql.Literal{}},
+		"Name = null":              &fql.CompareWithCall{Left: name, Op: fql.TOKEN_OP_EQ, Right: &fql.Literal{}},
+		"null":                     &fql.Literal{},
+	} {
+		s := cond.String()
+		if s != expected {
+			t.Errorf("expected %s but got %s", expected, s)
+			continue
+		}
+		again, err := fql.Parse(s, cfg)
+		if err != nil {
+			t.Errorf("parse %s error %+v", s, err)
+		} else if again.String() != s {
+			t.Errorf("%s got %s after parsed again", s, again.String())
+		}
+		if data, err := fql.MarshalFilter(cond); err != nil {
+			t.Errorf("%s marshal error %+v", s, err)
+		} else if back, err := fql.UnmarshalFilter(data, cfg); err != nil {
+			t.Errorf("%s unmarshal error %+v", s, err)
+		} else if back.String() != s {
+			t.Errorf("%s got %s after unmarshaled", s, back.String())
+		}
+	}
+
+	// NaN and the infinities have no source nor JSON
+	for expected, v := range map[string]float64{
+		"Name < <NaN>":  math.NaN(),
+		"Name < <+Inf>": math.Inf(1),
+		"Name < <-Inf>": math.Inf(-1),
+	} {
+		cond := &fql.CompareWithCall{Left: name, Op: fql.TOKEN_OP_LT, Right: &fql.Literal{Value: v}}
+		if s := cond.String(); s != expected {
+			t.Errorf("expected %s but got %s", expected, s)
+		} else if _, err := fql.Parse(s, cfg); err == nil {
+			t.Errorf("parse %s expected error", s)
+		}
+		if _, err := fql.MarshalFilter(cond); !errors.Is(err, fql.ErrInvalidFilter) {
+			t.Errorf("%s expected invalid filter but got %+v", expected, err)
+		}
+	}
+}
+
+type Address struct {
+	City string
+	Zip  *int
+}
+
+type User struct {
+	Name    string
+	Address *Address
+	Tags    []string
+	Extra   map[string]any
+	Scores  map[int]float64
+}
+
+func TestFieldPath(t *testing.T) {
+	zip := 10001
+	envs := []any{
+		&User{Name: "ann", Address: &Address{City: "NYC", Zip: &zip}, Tags: []string{"vip", "beta"},
+			Extra: map[string]any{"plan": "pro", "seats": 5, "owner": map[string]any{"id": 1}}, Scores: map[int]float64{1: 9.5}},
+		&User{Name: "bob", Address: &Address{City: "LA"}, Tags: []string{"beta"}},
+		&User{Name: "cid"},
+		map[string]any{"Name": "dan", "Address": map[string]any{"City": "NYC", "Zip": 10002}, "Tags": []any{"vip"},
+			"Extra": map[string]any{"first name": "Dan", "seats": 2}},
+		nil,
+	}
+	for query, expected := range map[string]string{
+		"Address.City = 'NYC'":                                 "0,3",
+		"Address.Zip > 10000":                                  "0,3",
+		"Address.Zip is null":                                  "1,2,4",
+		"Tags[0] = 'vip'":                                      "0,3",
+		"Tags[1] is not null":                                  "0",
+		"Extra.seats * 2 >= 4 and Extra['plan'] is null":       "3",
+		"Extra.owner.id = 1 and Scores[1] between 9 and 10":    "0",
+		"Extra['first name'] = 'Dan'":                          "3",
+		"Name in ('bob', 'cid') or lower(Name) like 'd%'":      "1,2,3",
+		"Address.City.Nosuch is null and Tags[-1] is null":     "0,1,2,3,4",
+		"Address.Zip is null and (Name is null or Name < 'c')": "1,4",
+	} {
+		cond, err := fql.Parse(query, cfg)
+		if err != nil {
+			t.Errorf("parse %s error %+v", query, err)
+			continue
+		}
+		ids := []int{}
+		for i, env := range envs {
+			// a missing field is null, compared with which is unknown
+			ctx := fql.NewContext(env)
+			ctx.ThreeValued = true
+			if matched, err := cond.IsTrue(ctx); err != nil && err != fql.ErrUnknown {
+				t.Errorf("%s env %d error %+v", query, i, err)
+			} else if matched {
+				ids = append(ids, i)
+			}
+		}
+		if got := joinInts(ids); got != expected {
+			t.Errorf("%s want %s got %s", query, expected, got)
+		}
+	}
+	for query, pos := range map[string]int{
+		"a. = 1":     4,
+		"a[1.5] = 1": 5,
+		"a['x' = 1":  7,
+	} {
+		if _, err := fql.Parse(query, cfg); err == nil {
+			t.Errorf("parse %s expected error", query)
+		} else if pe, is := err.(*fql.ParseError); !is || pe.Pos != pos {
+			t.Errorf("parse %s got error %+v", query, err)
+		}
+	}
+}
+
+func TestContextCancel(t *testing.T) {
+	type ctxKey struct{}
+	calls := 0
+	conf := &fql.ParseConfig{
+		StrMethods: map[string]func(any, string) (any, error){
+			"rec": func(env any, name string) (any, error) {
+				calls++
+				return reflect.ValueOf(env).Elem().FieldByName(name).Interface(), nil
+			},
+		},
+		CtxStrMethods: map[string]func(context.Context, any, string) (any, error){
+			"tenant": func(ctx context.Context, _ any, _ string) (any, error) {
+				return ctx.Value(ctxKey{}), nil
+			},
+			"slow": func(ctx context.Context, _ any, _ string) (any, error) {
+				<-ctx.Done()
+				return nil, ctx.Err()
+			},
+		},
+		Methods: map[string]fql.Method{
+			"user": {CtxFn: func(ctx context.Context, _ any, _ []any) (any, error) {
+				return ctx.Value(ctxKey{}), nil
+			}},
+		},
+	}
+	mustParse := func(query string) fql.BoolAst {
+		cond, err := fql.Parse(query, conf)
+		if err != nil {
+			t.Fatalf("parse %s error %+v", query, err)
+		}
+		return cond
+	}
+
+	ctx := fql.NewContext(&records[0])
+	ctx.Ctx = context.WithValue(context.Background(), ctxKey{}, "acme")
+	for _, query := range []string{"tenant('') = 'acme'", "user() = 'acme'", "rec('Name') < tenant('x')"} {
+		if matched, err := mustParse(query).IsTrue(ctx); err != nil || !matched {
+			t.Errorf("%s expected matched but got %v %+v", query, matched, err)
+		}
+	}
+
+	canceled, cancel := context.WithCancel(context.Background())
+	cancel()
+	ctx.Ctx = canceled
+	calls = 0
+	_, err := mustParse("rec('ID') = 1 or rec('ID') = 2").IsTrue(ctx)
+	if !errors.Is(err, fql.ErrCanceled) || !errors.Is(err, context.Canceled) {
+		t.Errorf("expected canceled but got %+v", err)
+	}
+	if calls != 0 {
+		t.Errorf("expected no method called but got %d", calls)
+	}
+
+	deadline, cancel := context.WithTimeout(context.Background(), time.Millisecond)
+	defer cancel()
+	ctx.Ctx = deadline
+	_, err = mustParse("slow('') = 1 and rec('ID') = 1").IsTrue(ctx)
+	if !errors.Is(err, fql.ErrCanceled) || !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("expected deadline exceeded but got %+v", err)
+	}
+}
+
 func BenchmarkFilterGetFieldBySwitch(b *testing.B) {
 	cond, _ := fql.Parse("rec('Source') = 1 and not (rec('ID') = 3 or rec('ID') = 5)", cfg)
 	ctx := fql.NewContext(nil)
@@ -271,3 +908,21 @@ func BenchmarkFilterUsingIn(b *testing.B) {
 		}
 	}
 }
+
+func BenchmarkFilterParallel(b *testing.B) {
+	cond, err := fql.Parse("rec('Source') in (1, 3) and rec('Level') * 2 > rec('ID') or rec('Name') like '%a%'", cfg)
+	if err != nil {
+		b.Fatalf("parse error %+v", err)
+	}
+	n := len(records)
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		ctx := fql.NewContext(nil)
+		for j := 0; pb.Next(); j++ {
+			ctx.Env = &records[j%n]
+			if _, err := cond.IsTrue(ctx); err != nil {
+				b.Errorf("eval error %+v", err)
+			}
+		}
+	})
+}
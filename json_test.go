@@ -0,0 +1,100 @@
+package filterql_test
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"testing"
+
+	fql "github.com/lennon-guan/filterql"
+)
+
+func TestMarshalFilter(t *testing.T) {
+	for _, query := range []string{
+		"rec('Source') = 1",
+		"rec('Source') in (1, 3) and rec('ID') <> 2",
+		"not (rec('ID') = 1 or rec('ID') = 2) and rec('Level') > 5",
+		"not rec('ID') in (1, 2)",
+		"rec('Score') > 4 or rec('Score') in (3.0, 4.5)",
+		"level_div(3.0) > 2.5 and rec('Level') >= -1",
+		"rec('Name') = 'it\\'s\\t\\\\'",
+		"(rec('Level') - 2) * 2 + -1 > rec('ID') - (1 - rec('ID'))",
+		"rec('Source') in arg('sources') or rec('Level') = double(rec('ID'))",
+		"opt('Name') is not null and opt('Name') is null",
+		"rec('Name') not like 'A%' or rec('Name') starts with 'B'",
+		"rec('Name') ~ '^[AB]' or rec('Name') !~ 'e$'",
+		"rec('Level') not between 8 and 11 exclusive",
+		"bucket('ID', 3) = one() or env('one_or_three') = true",
+		"not env('one_or_three') and true",
+		"Level > 8 or Name = 'Fig'",
+	} {
+		cond, err := fql.Parse(query, cfg)
+		if err != nil {
+			t.Errorf("parse %s error %+v", query, err)
+			continue
+		}
+		data, err := fql.MarshalFilter(cond)
+		if err != nil {
+			t.Errorf("marshal %s error %+v", query, err)
+			continue
+		}
+		again, err := fql.UnmarshalFilter(data, cfg)
+		if err != nil {
+			t.Errorf("unmarshal %s error %+v", data, err)
+			continue
+		} else if again.String() != cond.String() {
+			t.Errorf("%s got %s after unmarshaled", cond.String(), again.String())
+		}
+		// a bare node is accepted too
+		node, err := json.Marshal(cond)
+		if err != nil {
+			t.Errorf("marshal node %s error %+v", query, err)
+		} else if bare, err := fql.UnmarshalFilter(node, cfg); err != nil {
+			t.Errorf("unmarshal %s error %+v", node, err)
+		} else if bare.String() != cond.String() {
+			t.Errorf("%s got %s after unmarshaled", cond.String(), bare.String())
+		}
+		for i := range records {
+			ctx := fql.NewContext(&records[i])
+			m1, err1 := cond.IsTrue(ctx)
+			m2, err2 := again.IsTrue(ctx)
+			if m1 != m2 || fmt.Sprint(err1) != fmt.Sprint(err2) {
+				t.Errorf("%s on record %d got %v %v and %v %v", query, i, m1, err1, m2, err2)
+			}
+		}
+	}
+}
+
+func TestUnmarshalFilterError(t *testing.T) {
+	cond, err := fql.Parse("rec('ID') = 1 and double(rec('Level')) > 2", cfg)
+	if err != nil {
+		t.Fatalf("parse error %+v", err)
+	}
+	data, err := fql.MarshalFilter(cond)
+	if err != nil {
+		t.Fatalf("marshal error %+v", err)
+	}
+	if _, err := fql.UnmarshalFilter(data, nil); !errors.Is(err, fql.ErrNoSuchMethod) {
+		t.Errorf("expected ErrNoSuchMethod but got %+v", err)
+	}
+	for data, expected := range map[string]error{
+		`{"version": 2, "filter": {"type": "literal", "kind": "bool", "value": true}}`: fql.ErrUnsupportedVersion,
+		`{"version": 1}`:                  fql.ErrInvalidFilter,
+		`{"type": "and", "children": []}`: fql.ErrInvalidFilter,
+		`{"type": "xor", "children": []}`: fql.ErrInvalidFilter,
+		`{"type": "compare", "op": "=", "left": {"type": "field", "path": [{"name": "ID"}]}}`:                                                               fql.ErrInvalidFilter,
+		`{"type": "compare", "op": "in", "left": {"type": "field", "path": [{"name": "ID"}]}, "right": {"type": "literal", "kind": "int", "value": 1}}`:     fql.ErrInvalidFilter,
+		`{"type": "compare", "op": ">", "left": {"type": "literal", "kind": "str", "value": "a"}, "right": {"type": "literal", "kind": "int", "value": 1}}`: fql.ErrTypeNotMatched,
+		`{"type": "in", "left": {"type": "field", "path": [{"name": "ID"}]}, "choices": [{"type": "literal", "kind": "int", "value": "x"}]}`:                fql.ErrInvalidFilter,
+		`{"type": "match", "op": "like", "left": {"type": "field", "path": [{"name": "Name"}]}}`:                                                            fql.ErrInvalidFilter,
+		`{"type": "field", "path": [{"index": 0}]}`: fql.ErrInvalidFilter,
+		`{"type": "compare", "op": "TOKEN_EOF", "left": {"type": "field", "path": [{"name": "ID"}]}, "right": {"type": "literal", "kind": "int", "value": 1}}`: fql.ErrInvalidFilter,
+		`{"type": "arith", "op": "TOKEN_FLOAT", "left": {"type": "field", "path": [{"name": "ID"}]}, "right": {"type": "literal", "kind": "int", "value": 1}}`: fql.ErrInvalidFilter,
+		`{"type": "match", "op": "TOKEN_IS", "left": {"type": "field", "path": [{"name": "Name"}]}, "pattern": "a"}`:                                           fql.ErrInvalidFilter,
+		`{"type": "call", "name": "rec", "args": [{"type": "literal", "kind": "int", "value": 99999999999999999999999}]}`:                                      fql.ErrNumberOutOfRange,
+	} {
+		if _, err := fql.UnmarshalFilter([]byte(data), cfg); !errors.Is(err, expected) {
+			t.Errorf("%s expected %v but got %+v", data, expected, err)
+		}
+	}
+}
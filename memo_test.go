@@ -0,0 +1,202 @@
+package filterql_test
+
+import (
+	"testing"
+
+	fql "github.com/lennon-guan/filterql"
+)
+
+// countingConfig returns cfg with the methods counting their calls in calls.
+func countingConfig(calls map[string]int) *fql.ParseConfig {
+	conf := *cfg
+	conf.StrMethods = map[string]func(any, string) (any, error){}
+	for name, fn := range cfg.StrMethods {
+		name, fn := name, fn
+		conf.StrMethods[name] = func(env any, arg string) (any, error) {
+			calls[name+"('"+arg+"')"]++
+			return fn(env, arg)
+		}
+	}
+	conf.Methods = map[string]fql.Method{
+		"echo": {
+			Params: []fql.Type{fql.TypeAny},
+			Fn: func(env any, args []any) (any, error) {
+				calls["echo"]++
+				return args[0], nil
+			},
+		},
+		"tick": {
+			NonDeterministic: true,
+			Fn: func(env any, args []any) (any, error) {
+				calls["tick"]++
+				return calls["tick"], nil
+			},
+		},
+	}
+	return &conf
+}
+
+func TestMemoize(t *testing.T) {
+	calls := map[string]int{}
+	conf := countingConfig(calls)
+	cond, err := fql.Parse("rec('Source') = 1 or rec('Source') = 3 or rec('Source') in arg('sources')", conf)
+	if err != nil {
+		t.Fatalf("parse error %+v", err)
+	}
+	ctx := &fql.Context{Env: &records[6], Memoize: true}
+	for i := 0; i < 2; i++ {
+		if matched, err := cond.IsTrue(ctx); err != nil || matched {
+			t.Errorf("expected not matched but got %v %+v", matched, err)
+		}
+	}
+	if calls["rec('Source')"] != 1 || calls["arg('sources')"] != 1 {
+		t.Errorf("expected one call each but got %v", calls)
+	}
+
+	// the results are dropped with the env they are of
+	ctx.Env = &records[5]
+	if matched, err := cond.IsTrue(ctx); err != nil || !matched {
+		t.Errorf("expected matched for another env but got %v %+v", matched, err)
+	}
+	if calls["rec('Source')"] != 2 {
+		t.Errorf("expected a call for another env but got %v", calls)
+	}
+	ctx.Reset()
+	if matched, err := cond.IsTrue(ctx); err != nil || !matched {
+		t.Errorf("expected matched after reset but got %v %+v", matched, err)
+	}
+	if calls["rec('Source')"] != 3 {
+		t.Errorf("expected a call after reset but got %v", calls)
+	}
+
+	delete(calls, "rec('Source')")
+	if matched, err := cond.IsTrue(fql.NewContext(&records[6])); err != nil || matched {
+		t.Errorf("expected not matched but got %v %+v", matched, err)
+	} else if calls["rec('Source')"] != 3 {
+		t.Errorf("expected 3 calls without memoizing but got %v", calls)
+	}
+}
+
+func TestMemoizeEnvReuse(t *testing.T) {
+	calls := map[string]int{}
+	conf := countingConfig(calls)
+	cond, err := fql.Parse("rec('Name') = 'Apple' or rec('Name') = 'Fig'", conf)
+	if err != nil {
+		t.Fatalf("parse error %+v", err)
+	}
+	// Reset is not called between the envs
+	ctx := &fql.Context{Memoize: true}
+	for i, expected := range map[int]bool{0: true, 1: false, 5: true, 6: false} {
+		ctx.Env = &records[i]
+		if matched, err := cond.IsTrue(ctx); err != nil || matched != expected {
+			t.Errorf("record %d expected %v but got %v %+v", i, expected, matched, err)
+		}
+	}
+	if calls["rec('Name')"] != 4 {
+		t.Errorf("expected a call per env but got %v", calls)
+	}
+}
+
+func TestMemoizeMethods(t *testing.T) {
+	calls := map[string]int{}
+	conf := countingConfig(calls)
+	conf.NonDeterministic = map[string]bool{"lower": true}
+	cond, err := fql.Parse("echo(1) = 1 and echo(1) = 1 and echo(1.0) = 1.0 and echo('1') = '1'"+
+		" and tick() <> tick() and lower('A') = lower('A')", conf)
+	if err != nil {
+		t.Fatalf("parse error %+v", err)
+	}
+	ctx := &fql.Context{Env: &records[0], Memoize: true}
+	if matched, err := cond.IsTrue(ctx); err != nil || !matched {
+		t.Errorf("expected matched but got %v %+v", matched, err)
+	}
+	if calls["echo"] != 3 || calls["tick"] != 2 || calls["lower('A')"] != 2 {
+		t.Errorf("unexpected calls %v", calls)
+	}
+}
+
+func TestMemoizeErrors(t *testing.T) {
+	calls := map[string]int{}
+	conf := countingConfig(calls)
+	cond, err := fql.Parse("arg('bad') = 1 or arg('bad') = 2", conf)
+	if err != nil {
+		t.Fatalf("parse error %+v", err)
+	}
+	ctx := &fql.Context{Env: &records[0], Memoize: true}
+	for i := 0; i < 2; i++ {
+		if _, err := cond.IsTrue(ctx); err == nil {
+			t.Errorf("expected an error")
+		}
+	}
+	if calls["arg('bad')"] != 2 {
+		t.Errorf("expected the failed calls repeated but got %v", calls)
+	}
+}
+
+func TestMemoizeBatch(t *testing.T) {
+	calls := map[string]int{}
+	conf := countingConfig(calls)
+	cond, err := fql.Parse("rec('Level') > 8 and rec('Level') < 15", conf)
+	if err != nil {
+		t.Fatalf("parse error %+v", err)
+	}
+	matched, err := fql.FilterSlice(cond, recordPtrs(), &fql.BatchOptions{Memoize: true})
+	if err != nil {
+		t.Fatalf("filter error %+v", err)
+	}
+	var ids []int
+	for _, rec := range matched {
+		ids = append(ids, rec.ID)
+	}
+	if s := joinInts(ids); s != "1,7" {
+		t.Errorf("expected 1,7 but got %s", s)
+	}
+	if calls["rec('Level')"] != len(records) {
+		t.Errorf("expected a call per record but got %v", calls)
+	}
+
+	f, err := fql.Compile[Record]("rec('Level') > 8", conf)
+	if err != nil {
+		t.Fatalf("compile error %+v", err)
+	}
+	ctx := &fql.Context{Memoize: true}
+	for i := range records {
+		m1, _ := f.MatchWith(ctx, &records[i])
+		m2, _ := f.Match(&records[i])
+		if m1 != m2 {
+			t.Errorf("record %d got %v with memo but %v without", i, m1, m2)
+		}
+	}
+}
+
+func TestOptimizeNonDeterministic(t *testing.T) {
+	calls := map[string]int{}
+	conf := countingConfig(calls)
+	conf.NonDeterministic = map[string]bool{"lower": true}
+	for _, query := range []string{
+		"tick() = 1 or tick() = 1",
+		"tick() and not tick()",
+		"tick() = 1 or tick() = 2",
+		"tick() = 1 or tick() <> 1",
+		"lower('A') = 'a' and lower('A') = 'a'",
+		"lower('A') in ('a', 'b') and lower('A') in ('c')",
+	} {
+		cond, err := fql.Parse(query, conf)
+		if err != nil {
+			t.Errorf("parse %s error %+v", query, err)
+			continue
+		}
+		for _, optimized := range []fql.BoolAst{fql.Optimize(cond), fql.OptimizeAssumeValid(cond)} {
+			if s := optimized.String(); s != cond.String() {
+				t.Errorf("%s expected unchanged but got %s", query, s)
+			}
+		}
+	}
+	cond, err := fql.Parse("tick() > 0 and false", conf)
+	if err != nil {
+		t.Fatalf("parse error %+v", err)
+	}
+	if s := fql.OptimizeAssumeValid(cond).String(); s != "tick() > 0 and false" {
+		t.Errorf("expected the call kept but got %s", s)
+	}
+}
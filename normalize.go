@@ -0,0 +1,264 @@
+package filterql
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// NormalForm is a form Normalize converts a filter to.
+type NormalForm int
+
+const (
+	// DNF is an OR of ANDs of conditions.
+	DNF NormalForm = iota
+	// CNF is an AND of ORs of conditions.
+	CNF
+)
+
+// Normalize converts cond to the normal form. The negations are pushed down
+// to the conditions, then AND and OR are distributed over each other. The
+// conditions of a clause and the clauses are deduplicated and sorted by
+// their source, and so are the literals of IN lists, so the filters which
+// only differ by the order or the grouping of their conditions get the same
+// result. true and false are dropped or absorb the clause they are in, and
+// the clauses absorbed by others are dropped.
+//
+// The result may be exponentially larger than cond, so if it would have more
+// than maxSize conditions, Normalize fails with ErrTooComplex. A maxSize of
+// 0 or less is no limit.
+func Normalize(cond BoolAst, form NormalForm, maxSize int) (BoolAst, error) {
+	n := normalizer{and: form == CNF, maxSize: maxSize}
+	clauses, err := n.clauses(cond)
+	if err != nil {
+		return nil, err
+	}
+	return n.build(clauses), nil
+}
+
+// Fingerprint returns a hash of the DNF of cond, which is the same for the
+// filters Normalize gives the same result for. maxSize is as in Normalize.
+func Fingerprint(cond BoolAst, maxSize int) (string, error) {
+	norm, err := Normalize(cond, DNF, maxSize)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256([]byte(norm.String()))
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// normalizer converts to a list of clauses. and tells if the outer junction
+// is an AND, i.e. the form is CNF.
+type normalizer struct {
+	and     bool
+	maxSize int
+}
+
+// clause is a junction of conditions, an empty one is its identity. A nil
+// clause list is the identity of the outer junction.
+type clause []BoolAst
+
+func clausesSize(clauses []clause) int {
+	size := 0
+	for _, c := range clauses {
+		size += len(c)
+	}
+	return size
+}
+
+func (n *normalizer) check(size int) error {
+	if n.maxSize > 0 && size > n.maxSize {
+		return fmt.Errorf("%d conditions over %d: %w", size, n.maxSize, ErrTooComplex)
+	}
+	return nil
+}
+
+// clauses returns the clauses of cond. The true of an outer OR gives a
+// single empty clause, as does the false of an outer AND.
+func (n *normalizer) clauses(cond BoolAst) ([]clause, error) {
+	switch c := cond.(type) {
+	case *NOT:
+		return n.clauses(c.Child.Not())
+	case *ANDs:
+		return n.junction(c.Children, n.and)
+	case *ORs:
+		return n.junction(c.Children, !n.and)
+	case *Literal:
+		if isTruthy(c.Value) != n.and {
+			// absorbs the outer junction
+			return []clause{{}}, nil
+		}
+		// the identity of the outer junction
+		return nil, nil
+	}
+	return []clause{{canonicalAtom(cond)}}, nil
+}
+
+// junction returns the clauses of the children of a junction. The clauses
+// are concatenated if it's the outer junction, and distributed otherwise.
+func (n *normalizer) junction(children []BoolAst, outer bool) ([]clause, error) {
+	var result []clause
+	for i, child := range children {
+		clauses, err := n.clauses(child)
+		if err != nil {
+			return nil, err
+		}
+		switch {
+		case outer:
+			result = append(result, clauses...)
+		case i == 0:
+			result = clauses
+		default:
+			if err := n.check(len(result)*clausesSize(clauses) + len(clauses)*clausesSize(result)); err != nil {
+				return nil, err
+			}
+			product := make([]clause, 0, len(result)*len(clauses))
+			for _, a := range result {
+				for _, b := range clauses {
+					product = append(product, append(append(clause{}, a...), b...))
+				}
+			}
+			result = product
+		}
+		if err := n.check(clausesSize(result)); err != nil {
+			return nil, err
+		}
+		if !outer && result == nil {
+			// the identity of the outer junction absorbs the inner one
+			return nil, nil
+		}
+	}
+	return result, nil
+}
+
+// build deduplicates and sorts the clauses and builds the filter. A clause
+// with all the conditions of another one is dropped, since a or a and b is
+// a, and a and (a or b) is a too.
+func (n *normalizer) build(clauses []clause) BoolAst {
+	var (
+		nodes []BoolAst
+		keys  []string
+		sets  []map[string]bool
+	)
+	for _, c := range clauses {
+		set := map[string]bool{}
+		for _, cond := range c {
+			set[cond.String()] = true
+		}
+		if len(set) == 0 {
+			// an empty clause absorbs the outer junction
+			return &Literal{Value: !n.and}
+		}
+		node := n.buildClause(c)
+		nodes, keys, sets = append(nodes, node), append(keys, node.String()), append(sets, set)
+	}
+	var (
+		kept     []BoolAst
+		keptKeys []string
+	)
+	for i, node := range nodes {
+		absorbed := false
+		for j, other := range sets {
+			// the first one of the same clauses is kept
+			if j != i && isSubset(other, sets[i]) && (len(other) < len(sets[i]) || j < i) {
+				absorbed = true
+				break
+			}
+		}
+		if !absorbed {
+			kept, keptKeys = append(kept, node), append(keptKeys, keys[i])
+		}
+	}
+	sortNodes(kept, keptKeys)
+	return junctionOf(kept, n.and)
+}
+
+func isSubset(a, b map[string]bool) bool {
+	for key := range a {
+		if !b[key] {
+			return false
+		}
+	}
+	return true
+}
+
+func (n *normalizer) buildClause(c clause) BoolAst {
+	var (
+		nodes []BoolAst
+		keys  []string
+		seen  = map[string]bool{}
+	)
+	for _, cond := range c {
+		if key := cond.String(); !seen[key] {
+			seen[key] = true
+			nodes = append(nodes, cond)
+			keys = append(keys, key)
+		}
+	}
+	sortNodes(nodes, keys)
+	return junctionOf(nodes, !n.and)
+}
+
+// junctionOf returns the AND of nodes if and is set, or else the OR of them.
+// No node is the identity of the junction.
+func junctionOf(nodes []BoolAst, and bool) BoolAst {
+	switch {
+	case len(nodes) == 0:
+		return &Literal{Value: and}
+	case len(nodes) == 1:
+		return nodes[0]
+	case and:
+		return &ANDs{Children: nodes}
+	}
+	return &ORs{Children: nodes}
+}
+
+type nodesByKey struct {
+	nodes []BoolAst
+	keys  []string
+}
+
+func (s nodesByKey) Len() int           { return len(s.nodes) }
+func (s nodesByKey) Less(i, j int) bool { return s.keys[i] < s.keys[j] }
+func (s nodesByKey) Swap(i, j int) {
+	s.nodes[i], s.nodes[j] = s.nodes[j], s.nodes[i]
+	s.keys[i], s.keys[j] = s.keys[j], s.keys[i]
+}
+
+func sortNodes(nodes []BoolAst, keys []string) {
+	sort.Stable(nodesByKey{nodes: nodes, keys: keys})
+}
+
+// canonicalAtom sorts and deduplicates the literals of an IN list.
+func canonicalAtom(cond BoolAst) BoolAst {
+	n, is := cond.(choiceNode)
+	if !is {
+		return cond
+	}
+	set, ok := n.choiceSet()
+	if !ok || len(set.values) < 2 {
+		return cond
+	}
+	values := uniqueValues(set.values)
+	sort.SliceStable(values, func(i, j int) bool {
+		return lessValue(values[i], values[j])
+	})
+	set.values = values
+	if node, err := set.node(); err == nil {
+		return node
+	}
+	return cond
+}
+
+// lessValue orders the literals of a list, which are all numbers or all
+// strings.
+func lessValue(a, b any) bool {
+	if na, is := toNumber(a); is {
+		if nb, is := toNumber(b); is {
+			return compareNumbers(na, nb, TOKEN_OP_LT)
+		}
+	}
+	return strings.Compare(literalString(a), literalString(b)) < 0
+}
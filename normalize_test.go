@@ -0,0 +1,116 @@
+package filterql_test
+
+import (
+	"errors"
+	"testing"
+
+	fql "github.com/lennon-guan/filterql"
+)
+
+func TestNormalize(t *testing.T) {
+	for query, expected := range map[string][2]string{
+		"rec('ID') = 1": {"rec('ID') = 1", "rec('ID') = 1"},
+		"rec('Source') = 1 and (rec('ID') = 2 or rec('Level') > 8)": {
+			"rec('ID') = 2 and rec('Source') = 1 or rec('Level') > 8 and rec('Source') = 1",
+			"(rec('ID') = 2 or rec('Level') > 8) and rec('Source') = 1",
+		},
+		"rec('ID') = 1 or rec('ID') = 2 and rec('Level') > 5": {
+			"rec('ID') = 1 or rec('ID') = 2 and rec('Level') > 5",
+			"(rec('ID') = 1 or rec('ID') = 2) and (rec('ID') = 1 or rec('Level') > 5)",
+		},
+		"not (rec('ID') = 1 or rec('Name') like 'A%') and rec('ID') = 1": {
+			"rec('ID') <> 1 and rec('ID') = 1 and rec('Name') not like 'A%'",
+			"rec('ID') <> 1 and rec('ID') = 1 and rec('Name') not like 'A%'",
+		},
+		"rec('ID') in (3, 1, 2) and rec('Name') in ('b', 'a') and rec('ID') in (1, 2, 3)": {
+			"rec('ID') in (1, 2, 3) and rec('Name') in ('a', 'b')",
+			"rec('ID') in (1, 2, 3) and rec('Name') in ('a', 'b')",
+		},
+		"(rec('ID') = 1 or false) and (true or rec('ID') = 2)": {"rec('ID') = 1", "rec('ID') = 1"},
+		"rec('ID') = 1 or rec('ID') = 1 and rec('Level') > 5":  {"rec('ID') = 1", "rec('ID') = 1"},
+		"rec('ID') = 1 and false":                              {"false", "false"},
+		"rec('ID') = 1 or true":                                {"true", "true"},
+	} {
+		cond, err := fql.Parse(query, cfg)
+		if err != nil {
+			t.Errorf("parse %s error %+v", query, err)
+			continue
+		}
+		for i, form := range []fql.NormalForm{fql.DNF, fql.CNF} {
+			norm, err := fql.Normalize(cond, form, 0)
+			if err != nil {
+				t.Errorf("normalize %s error %+v", query, err)
+				continue
+			} else if s := norm.String(); s != expected[i] {
+				t.Errorf("%s form %d expected %s but got %s", query, form, expected[i], s)
+			}
+			for j := range records {
+				ctx := fql.NewContext(&records[j])
+				m1, err1 := cond.IsTrue(ctx)
+				m2, err2 := norm.IsTrue(ctx)
+				if err1 == nil && (m1 != m2 || err2 != nil) {
+					t.Errorf("%s on record %d got %v and %v %v", query, j, m1, m2, err2)
+				}
+			}
+		}
+	}
+}
+
+func TestFingerprint(t *testing.T) {
+	fingerprint := func(query string) string {
+		cond, err := fql.Parse(query, cfg)
+		if err != nil {
+			t.Fatalf("parse %s error %+v", query, err)
+		}
+		fp, err := fql.Fingerprint(cond, 100)
+		if err != nil {
+			t.Fatalf("fingerprint %s error %+v", query, err)
+		}
+		return fp
+	}
+	for _, same := range [][]string{
+		{
+			"rec('Source') = 1 and (rec('ID') = 2 or rec('Level') > 8)",
+			"rec('Level') > 8 and rec('Source') = 1 or rec('Source') = 1 and rec('ID') = 2",
+			"not (rec('Source') <> 1 or rec('ID') <> 2 and rec('Level') <= 8)",
+		},
+		{
+			"rec('ID') in (1, 2) and Name = 'Fig'",
+			"Name = 'Fig' and rec('ID') in (2, 1, 2)",
+		},
+	} {
+		fp := fingerprint(same[0])
+		for _, query := range same[1:] {
+			if other := fingerprint(query); other != fp {
+				t.Errorf("%s and %s expected the same fingerprint", same[0], query)
+			}
+		}
+	}
+	if fingerprint("rec('ID') = 1 and rec('Level') > 1") == fingerprint("rec('ID') = 1 or rec('Level') > 1") {
+		t.Errorf("expected different fingerprints")
+	}
+}
+
+func TestNormalizeTooComplex(t *testing.T) {
+	// (a1 or b1) and (a2 or b2) and ... has 2^n clauses in DNF
+	b := fql.NewBuilder(cfg)
+	var conds []fql.Cond
+	for i := 0; i < 12; i++ {
+		conds = append(conds, b.Or(b.Call("rec", "ID").Eq(i), b.Call("rec", "Level").Eq(i)))
+	}
+	cond, err := b.And(conds...).Build()
+	if err != nil {
+		t.Fatalf("build error %+v", err)
+	}
+	if _, err := fql.Normalize(cond, fql.DNF, 1000); !errors.Is(err, fql.ErrTooComplex) {
+		t.Errorf("expected ErrTooComplex but got %+v", err)
+	}
+	if norm, err := fql.Normalize(cond, fql.CNF, 1000); err != nil {
+		t.Errorf("normalize to CNF error %+v", err)
+	} else if n := len(norm.(*fql.ANDs).Children); n != 12 {
+		t.Errorf("expected 12 clauses but got %d", n)
+	}
+	if _, err := fql.Fingerprint(cond, 1000); !errors.Is(err, fql.ErrTooComplex) {
+		t.Errorf("expected ErrTooComplex but got %+v", err)
+	}
+}
@@ -0,0 +1,383 @@
+package filterql
+
+import (
+	"fmt"
+	"math"
+)
+
+// Optimize returns a simplified filter equivalent to cond, it's run by Parse
+// if ParseConfig.Optimize is set. cond itself is not changed.
+//
+//   - operations and conditions on literals only are folded, like 1 < 2 into
+//     true, unless they fail or give a NaN or an infinity
+//   - the duplicate children of AND and OR are removed
+//   - the equalities and IN lists on the same call next to each other are
+//     merged, so x = 1 or x = 2 becomes x in (1, 2) and x <> 1 and x <> 2
+//     becomes x not in (1, 2)
+//   - true is dropped from AND and false from OR, and the children after
+//     false in AND and true in OR are dropped
+//
+// The result fails, or is unknown, for the same envs as cond does. The
+// conditions calling a non-deterministic method are left as they are, so the
+// method is called as many times as in cond.
+func Optimize(cond BoolAst) BoolAst {
+	return optimize(cond, false)
+}
+
+// OptimizeAssumeValid is Optimize assuming that the conditions never fail,
+// i.e. the operands are never null nor of a mistyped value. It's run by Parse
+// instead of Optimize if ParseConfig.AssumeValid is set. On top of Optimize:
+//
+//   - the contradictions like x = 1 and x = 2 or y and not y become false,
+//     and the tautologies like x in (1, 2) or x not in (1, 2) become true
+//   - AND with false and OR with true become false and true
+//   - the equalities and IN lists on the same call are merged even if they
+//     are apart, so x = 1 or y = 2 or x = 3 becomes x in (1, 3) or y = 2
+//
+// x = 1 or x <> 1 is true, while it's unknown or an error for a null x, so
+// the result may match where cond fails.
+func OptimizeAssumeValid(cond BoolAst) BoolAst {
+	return optimize(cond, true)
+}
+
+// optimize optimizes cond, with the rewrites only holding for valid operands
+// if valid is set.
+func optimize(cond BoolAst, valid bool) BoolAst {
+	optimized, err := Rewrite(cond, func(node PrintableAst) (PrintableAst, error) {
+		return optimizeNode(node, valid)
+	})
+	if err != nil {
+		// the optimized nodes always fit where they are
+		return cond
+	}
+	return optimized
+}
+
+func optimizeNode(node PrintableAst, valid bool) (PrintableAst, error) {
+	switch n := node.(type) {
+	case *ANDs:
+		return simplifyJunction(n.Children, true, valid), nil
+	case *ORs:
+		return simplifyJunction(n.Children, false, valid), nil
+	case *NOT:
+		if lit, is := n.Child.(*Literal); is {
+			return &Literal{Value: !isTruthy(lit.Value)}, nil
+		}
+	case *Arith:
+		if !isConstant(n) {
+			break
+		} else if n.not {
+			// it's a condition
+			if v, err := n.IsTrue(NewContext(nil)); err == nil {
+				return &Literal{Value: v}, nil
+			}
+		} else if v, err := n.Eval(NewContext(nil)); err == nil && isFinite(v) {
+			// NaN and the infinities have no literal, so they're not folded
+			return &Literal{Value: v}, nil
+		}
+	case BoolAst:
+		if isConstant(n) {
+			if v, err := n.IsTrue(NewContext(nil)); err == nil {
+				return &Literal{Value: v}, nil
+			}
+		}
+	}
+	return node, nil
+}
+
+// isFinite tells if v isn't a NaN or infinite float.
+func isFinite(v any) bool {
+	f, is := v.(float64)
+	return !is || !math.IsInf(f, 0) && !math.IsNaN(f)
+}
+
+// isConstant tells if node is an operation or a condition on literals only.
+// The calls are never constant, even with literal arguments.
+func isConstant(node PrintableAst) bool {
+	switch node.(type) {
+	case MethodCall, CallCompare, CallIn, *Literal, *Field:
+		return false
+	}
+	p, is := node.(parent)
+	if !is {
+		return false
+	}
+	for _, child := range p.children() {
+		if _, is := child.(*Literal); !is {
+			return false
+		}
+	}
+	return true
+}
+
+// simplifyJunction simplifies the optimized children of an AND if and is
+// set, or else of an OR. The contradictions and tautologies are only folded
+// if valid is set.
+func simplifyJunction(children []BoolAst, and bool, valid bool) BoolAst {
+	// true is the identity of AND, false the one of OR, and the other one
+	// decides the outcome once it's reached, so the children after it are
+	// never evaluated
+	var (
+		kept []BoolAst
+		seen = map[string]bool{}
+	)
+	for _, child := range flattenJunction(children, and) {
+		if lit, is := child.(*Literal); is {
+			if isTruthy(lit.Value) == and {
+				continue
+			} else if len(kept) == 0 || valid && !anyVolatile(kept) {
+				return &Literal{Value: !and}
+			}
+			// the children before it may still fail
+			kept = append(kept, &Literal{Value: !and})
+			break
+		}
+		if hasVolatile(child) {
+			// each one calls the method again
+			kept = append(kept, child)
+		} else if key := child.String(); !seen[key] {
+			seen[key] = true
+			kept = append(kept, child)
+		}
+	}
+	kept, absorbed := mergeChoices(kept, and, valid)
+	if absorbed {
+		return &Literal{Value: !and}
+	}
+	for _, child := range kept {
+		if valid && !hasVolatile(child) && seen[child.Not().String()] {
+			return &Literal{Value: !and}
+		}
+	}
+	switch {
+	case len(kept) == 0:
+		return &Literal{Value: and}
+	case len(kept) == 1:
+		return kept[0]
+	case and:
+		return &ANDs{Children: kept}
+	}
+	return &ORs{Children: kept}
+}
+
+// hasVolatile tells if node calls a non-deterministic method.
+func hasVolatile(node PrintableAst) bool {
+	found := false
+	Inspect(node, func(n PrintableAst) bool {
+		if v, is := n.(interface{ isVolatile() bool }); is && v.isVolatile() {
+			found = true
+		}
+		return !found
+	})
+	return found
+}
+
+func anyVolatile(nodes []BoolAst) bool {
+	for _, node := range nodes {
+		if hasVolatile(node) {
+			return true
+		}
+	}
+	return false
+}
+
+// flattenJunction merges the children of the nested nodes of the same kind,
+// which are left by the folding of their siblings.
+func flattenJunction(children []BoolAst, and bool) []BoolAst {
+	var list []BoolAst
+	for _, child := range children {
+		if a, is := child.(*ANDs); is && and {
+			list = append(list, a.Children...)
+		} else if o, is := child.(*ORs); is && !and {
+			list = append(list, o.Children...)
+		} else {
+			list = append(list, child)
+		}
+	}
+	return list
+}
+
+// choiceSet is a check of a call against a set of literals: x = 1, x <> 1,
+// x in (1, 2) or x not in (1, 2).
+type choiceSet struct {
+	call   Call
+	values []any
+	not    bool
+}
+
+type choiceNode interface {
+	choiceSet() (choiceSet, bool)
+}
+
+func (c *Compare[T]) choiceSet() (choiceSet, bool) {
+	if c.Op != TOKEN_OP_EQ && c.Op != TOKEN_OP_NE {
+		return choiceSet{}, false
+	}
+	return choiceSet{call: c.Call, values: []any{c.Target}, not: c.Op == TOKEN_OP_NE}, true
+}
+
+func (c *In[T]) choiceSet() (choiceSet, bool) {
+	return choiceSet{call: c.Call, values: anySlice(c.Choices), not: c.NotIn}, true
+}
+
+func (c *callThenCompare[T1, T2]) choiceSet() (choiceSet, bool) {
+	if c.op != TOKEN_OP_EQ && c.op != TOKEN_OP_NE {
+		return choiceSet{}, false
+	}
+	call := &call[T1]{callee: c.callee, arg: c.arg}
+	return choiceSet{call: call, values: []any{c.target}, not: c.op == TOKEN_OP_NE}, true
+}
+
+func (c *callThenIn[T1, T2]) choiceSet() (choiceSet, bool) {
+	call := &call[T1]{callee: c.callee, arg: c.arg}
+	return choiceSet{call: call, values: anySlice(c.choices), not: c.not}, true
+}
+
+// mergeChoices merges the choice sets on the same call into one, in place of
+// the first of them. Unless valid is set, only the sets next to each other are
+// merged, since moving one ahead of its siblings changes which of them are
+// evaluated when it decides the junction. If they make the junction a contradiction for AND, or a
+// tautology for OR, absorbed is set when valid is, or else they are kept as
+// they are, since they still fail for a null or mistyped call.
+func mergeChoices(children []BoolAst, and bool, valid bool) (merged []BoolAst, absorbed bool) {
+	groups := map[string][]int{}
+	sets := make([]choiceSet, len(children))
+	prev, run := "", 0
+	for i, child := range children {
+		key := ""
+		if n, is := child.(choiceNode); is && !hasVolatile(child) {
+			if set, ok := n.choiceSet(); ok {
+				sets[i] = set
+				// values of different types are never equal, they are kept apart
+				key = fmt.Sprintf("%s\x00%T", set.call, set.values[0])
+			}
+		}
+		if key != prev {
+			run++
+		}
+		prev = key
+		if key == "" {
+			continue
+		} else if !valid {
+			key += fmt.Sprintf("\x00%d", run)
+		}
+		groups[key] = append(groups[key], i)
+	}
+	replaced := make([]BoolAst, len(children))
+	for _, members := range groups {
+		if len(members) < 2 {
+			continue
+		}
+		group := make([]choiceSet, len(members))
+		for i, m := range members {
+			group[i] = sets[m]
+		}
+		set, empty := combineChoices(group, and)
+		if empty && valid {
+			return nil, true
+		} else if empty {
+			continue
+		}
+		node, err := set.node()
+		if err != nil {
+			continue
+		}
+		replaced[members[0]] = node
+		for _, m := range members[1:] {
+			replaced[m] = &Literal{Value: and}
+		}
+	}
+	for i, child := range children {
+		if node := replaced[i]; node == nil {
+			merged = append(merged, child)
+		} else if _, is := node.(*Literal); !is {
+			merged = append(merged, node)
+		}
+	}
+	return merged, false
+}
+
+// combineChoices combines the sets of a group. For AND the positive sets are
+// intersected and the negative ones excluded, for OR the negative sets are
+// intersected and the positive ones excluded. empty is set if nothing is
+// left.
+func combineChoices(group []choiceSet, and bool) (set choiceSet, empty bool) {
+	// the sets intersected, the others are united
+	var (
+		inter    []any
+		hasInter bool
+		union    []any
+	)
+	for _, s := range group {
+		if s.not != and {
+			if hasInter {
+				inter = intersectValues(inter, s.values)
+			} else {
+				inter, hasInter = uniqueValues(s.values), true
+			}
+		} else {
+			union = unionValues(union, s.values)
+		}
+	}
+	if !hasInter {
+		return choiceSet{call: group[0].call, values: union, not: and}, false
+	}
+	values := subtractValues(inter, union)
+	return choiceSet{call: group[0].call, values: values, not: !and}, len(values) == 0
+}
+
+// node builds the node of the set, the same way as for a parsed list.
+func (s choiceSet) node() (BoolAst, error) {
+	choices := make([]TokenInfo, len(s.values))
+	for i, v := range s.values {
+		tok, err := valueToken(v)
+		if err != nil {
+			return nil, err
+		}
+		choices[i] = tok
+	}
+	return newChoices(&defaultConfig, s.call, choices, s.not)
+}
+
+func containsValue(values []any, v any) bool {
+	for _, item := range values {
+		if item == v {
+			return true
+		}
+	}
+	return false
+}
+
+func uniqueValues(values []any) []any {
+	return unionValues(nil, values)
+}
+
+func unionValues(a, b []any) []any {
+	list := append([]any{}, a...)
+	for _, v := range b {
+		if !containsValue(list, v) {
+			list = append(list, v)
+		}
+	}
+	return list
+}
+
+func intersectValues(a, b []any) []any {
+	var list []any
+	for _, v := range a {
+		if containsValue(b, v) {
+			list = append(list, v)
+		}
+	}
+	return list
+}
+
+func subtractValues(a, b []any) []any {
+	var list []any
+	for _, v := range a {
+		if !containsValue(b, v) {
+			list = append(list, v)
+		}
+	}
+	return list
+}
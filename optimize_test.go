@@ -0,0 +1,177 @@
+package filterql_test
+
+import (
+	"fmt"
+	"testing"
+
+	fql "github.com/lennon-guan/filterql"
+)
+
+func TestOptimize(t *testing.T) {
+	for query, expected := range map[string]string{
+		"1 < 2":                           "true",
+		"1 + 2 * 3 = 7 and rec('ID') > 2": "rec('ID') > 2",
+		"'abc' like 'a%' and 2.5 between 1 and 3":                           "true",
+		"1 in (2, 3) or rec('ID') = 4":                                      "rec('ID') = 4",
+		"rec('ID') = 1 or rec('ID') = 2 or rec('ID') = 3":                   "rec('ID') in (1, 2, 3)",
+		"rec('ID') = 1 or rec('Level') > 8 or rec('ID') in (2, 1)":          "rec('ID') = 1 or rec('Level') > 8 or rec('ID') in (2, 1)",
+		"rec('ID') = 1 or arg('bad') = 2 or rec('ID') = 3":                  "rec('ID') = 1 or arg('bad') = 2 or rec('ID') = 3",
+		"rec('ID') = 1 or rec('ID') = 2 or arg('bad') = 2 or rec('ID') = 3": "rec('ID') in (1, 2) or arg('bad') = 2 or rec('ID') = 3",
+		"rec('ID') <> 1 and rec('ID') <> 2":                                 "rec('ID') not in (1, 2)",
+		"rec('ID') in (1, 2, 3) and rec('ID') <> 2":                         "rec('ID') in (1, 3)",
+		"rec('ID') in (1, 2, 3) and rec('ID') in (3, 4)":                    "rec('ID') = 3",
+		"rec('ID') = 1 and rec('ID') = 2":                                   "rec('ID') = 1 and rec('ID') = 2",
+		"rec('ID') = 1 or rec('ID') <> 1":                                   "rec('ID') = 1 or rec('ID') <> 1",
+		"rec('ID') not in (1, 2) or rec('ID') not in (2, 3)":                "rec('ID') <> 2",
+		"rec('Score') = 4 or rec('Score') = 4.5":                            "rec('Score') = 4 or rec('Score') = 4.5",
+		"rec('Level') > 5 and rec('Source') = 1 and rec('Level') > 5":       "rec('Level') > 5 and rec('Source') = 1",
+		"rec('Name') like 'A%' or not rec('Name') like 'A%'":                "rec('Name') like 'A%' or rec('Name') not like 'A%'",
+		"(rec('ID') = 1 or true) and rec('Source') = 2":                     "(rec('ID') = 1 or true) and rec('Source') = 2",
+		"(true or rec('ID') = 1) and rec('Source') = 2":                     "rec('Source') = 2",
+		"rec('ID') = 1 and false and rec('Source') = 2":                     "rec('ID') = 1 and false",
+		"opt('Level') = 1 or opt('Level') <> 1":                             "opt('Level') = 1 or opt('Level') <> 1",
+		"opt('Level') and not opt('Level')":                                 "opt('Level') and not opt('Level')",
+		"opt('Level') = 1 and opt('Level') = 2":                             "opt('Level') = 1 and opt('Level') = 2",
+		"opt('Level') in (10, 6) or opt('Level') = 8":                       "opt('Level') in (10, 6, 8)",
+		"rec('Name') = 1 or rec('Name') <> 1":                               "rec('Name') = 1 or rec('Name') <> 1",
+		"(rec('ID') = 1 or 1 > 2) and (rec('Source') = 1 and 2 > 1)":        "rec('ID') = 1 and rec('Source') = 1",
+		"not (1 = 1) or Level > 8":                                          "Level > 8",
+		"double(rec('ID')) = 2 or double(rec('ID')) = 4":                    "double(rec('ID')) in (2, 4)",
+		"1 / 0 > 1 or rec('ID') = 1":                                        "1 / 0 > 1 or rec('ID') = 1",
+		"rec('Level') >= 10 or rec('Level') < 10 and rec('ID') = 2":         "rec('Level') >= 10 or rec('Level') < 10 and rec('ID') = 2",
+	} {
+		cond, err := fql.Parse(query, cfg)
+		if err != nil {
+			t.Errorf("parse %s error %+v", query, err)
+			continue
+		}
+		optimized := fql.Optimize(cond)
+		if s := optimized.String(); s != expected {
+			t.Errorf("%s expected %s but got %s", query, expected, s)
+		}
+		// the same outcome for null and mistyped operands too
+		for i := range records {
+			for _, threeValued := range []bool{false, true} {
+				ctx := &fql.Context{Env: &records[i], ThreeValued: threeValued}
+				m1, err1 := cond.IsTrue(ctx)
+				m2, err2 := optimized.IsTrue(ctx)
+				if m1 != m2 || fmt.Sprint(err1) != fmt.Sprint(err2) {
+					t.Errorf("%s on record %d got %v %v and %v %v", query, i, m1, err1, m2, err2)
+				}
+			}
+		}
+	}
+}
+
+func TestOptimizeAssumeValid(t *testing.T) {
+	for query, expected := range map[string]string{
+		"rec('ID') = 1 and rec('ID') = 2":                          "false",
+		"rec('ID') = 1 or rec('ID') <> 1":                          "true",
+		"rec('ID') not in (1, 2) or rec('ID') in (1, 2)":           "true",
+		"rec('Name') like 'A%' or not rec('Name') like 'A%'":       "true",
+		"(rec('ID') = 1 or true) and rec('Source') = 2":            "rec('Source') = 2",
+		"rec('ID') = 1 and false and rec('Source') = 2":            "false",
+		"rec('ID') = 1 or rec('ID') = 2":                           "rec('ID') in (1, 2)",
+		"rec('ID') = 1 or rec('Level') > 8 or rec('ID') in (2, 1)": "rec('ID') in (1, 2) or rec('Level') > 8",
+	} {
+		cond, err := fql.Parse(query, cfg)
+		if err != nil {
+			t.Errorf("parse %s error %+v", query, err)
+			continue
+		}
+		optimized := fql.OptimizeAssumeValid(cond)
+		if s := optimized.String(); s != expected {
+			t.Errorf("%s expected %s but got %s", query, expected, s)
+		}
+		for i := range records {
+			ctx := fql.NewContext(&records[i])
+			m1, err1 := cond.IsTrue(ctx)
+			m2, err2 := optimized.IsTrue(ctx)
+			if err1 == nil && (m1 != m2 || err2 != nil) {
+				t.Errorf("%s on record %d got %v and %v %v", query, i, m1, m2, err2)
+			}
+		}
+	}
+	// a null operand is unknown, but the optimized filter is true
+	cond, err := fql.Parse("opt('Level') = 1 or opt('Level') <> 1", cfg)
+	if err != nil {
+		t.Fatalf("parse error %+v", err)
+	}
+	ctx := &fql.Context{Env: &records[3], ThreeValued: true}
+	if _, err := cond.IsTrue(ctx); err != fql.ErrUnknown {
+		t.Errorf("expected unknown but got %+v", err)
+	} else if matched, err := fql.OptimizeAssumeValid(cond).IsTrue(ctx); !matched || err != nil {
+		t.Errorf("expected true but got %v %+v", matched, err)
+	}
+}
+
+func TestOptimizeNonFinite(t *testing.T) {
+	for query, expected := range map[string]string{
+		"rec('Score') < 1e308 * 10":           "rec('Score') < 1e+308 * 10",
+		"rec('Score') > -1e308 * 10 * 2":      "rec('Score') > -1e+308 * 10 * 2",
+		"rec('Score') < 1e308 * 10 and 1 < 2": "rec('Score') < 1e+308 * 10",
+	} {
+		cond, err := fql.Parse(query, cfg)
+		if err != nil {
+			t.Errorf("parse %s error %+v", query, err)
+			continue
+		}
+		optimized := fql.Optimize(cond)
+		if s := optimized.String(); s != expected {
+			t.Errorf("%s expected %s but got %s", query, expected, s)
+			continue
+		}
+		if _, err := fql.Parse(optimized.String(), cfg); err != nil {
+			t.Errorf("%s parse back error %+v", query, err)
+		}
+		if data, err := fql.MarshalFilter(optimized); err != nil {
+			t.Errorf("%s marshal error %+v", query, err)
+		} else if back, err := fql.UnmarshalFilter(data, cfg); err != nil {
+			t.Errorf("%s unmarshal error %+v", query, err)
+		} else if s := back.String(); s != expected {
+			t.Errorf("%s unmarshaled %s", query, s)
+		}
+		ctx := fql.NewContext(&records[0])
+		m1, err1 := cond.IsTrue(ctx)
+		m2, err2 := optimized.IsTrue(ctx)
+		if m1 != m2 || fmt.Sprint(err1) != fmt.Sprint(err2) {
+			t.Errorf("%s got %v %v but optimized %v %v", query, m1, err1, m2, err2)
+		}
+	}
+}
+
+func TestOptimizeMergedIn(t *testing.T) {
+	cond, err := fql.Parse("rec('Source') = 1 or rec('Source') = 3", cfg)
+	if err != nil {
+		t.Fatalf("parse error %+v", err)
+	}
+	if c, is := fql.Optimize(cond).(fql.CallIn); !is {
+		t.Errorf("expected a merged call but got %T", fql.Optimize(cond))
+	} else if fmt.Sprint(c.Choices()) != "[1 3]" {
+		t.Errorf("unexpected choices %v", c.Choices())
+	}
+}
+
+func TestParseOptimize(t *testing.T) {
+	optCfg := *cfg
+	optCfg.Optimize = true
+	cond, err := fql.Parse("rec('ID') = 1 or rec('ID') = 2 and 1 < 2", &optCfg)
+	if err != nil {
+		t.Fatalf("parse error %+v", err)
+	}
+	if s := cond.String(); s != "rec('ID') in (1, 2)" {
+		t.Errorf("expected optimized but got %s", s)
+	}
+	query := "rec('ID') = 1 or rec('ID') <> 1"
+	if cond, err := fql.Parse(query, &optCfg); err != nil {
+		t.Fatalf("parse error %+v", err)
+	} else if s := cond.String(); s != query {
+		t.Errorf("expected the tautology kept but got %s", s)
+	}
+	optCfg.AssumeValid = true
+	if cond, err := fql.Parse(query, &optCfg); err != nil {
+		t.Fatalf("parse error %+v", err)
+	} else if s := cond.String(); s != "true" {
+		t.Errorf("expected the tautology folded but got %s", s)
+	}
+}
@@ -1,6 +1,9 @@
 package filterql
 
-import "fmt"
+import (
+	"context"
+	"fmt"
+)
 
 type ParseError struct {
 	Err error
@@ -11,10 +14,23 @@ func (e *ParseError) Error() string {
 	return fmt.Sprintf("%d: %+v", e.Pos, e.Err)
 }
 
+func (e *ParseError) Unwrap() error {
+	return e.Err
+}
+
 func parseError(err error, pos int) *ParseError {
 	return &ParseError{Err: err, Pos: pos}
 }
 
+// bareError returns the error of a ParseError, for the nodes that are built
+// without a source.
+func bareError(err error) error {
+	if pe, is := err.(*ParseError); is {
+		return pe.Err
+	}
+	return err
+}
+
 func Parse(code string, cfg *ParseConfig) (BoolAst, error) {
 	if cfg == nil {
 		cfg = &defaultConfig
@@ -31,6 +47,11 @@ func Parse(code string, cfg *ParseConfig) (BoolAst, error) {
 	} else if ts.Current.Type != TOKEN_EOF {
 		return nil, ErrUnexpectedToken
 	} else {
+		if cfg.Optimize && cfg.AssumeValid {
+			cond = OptimizeAssumeValid(cond)
+		} else if cfg.Optimize {
+			cond = Optimize(cond)
+		}
 		if cacher := cfg.Cache; cacher != nil {
 			cacher.Store(code, cond)
 		}
@@ -102,6 +123,7 @@ func parseItem(ts *TokenStream, cfg *ParseConfig) (BoolAst, error) {
 
 func parseAtom(ts *TokenStream, cfg *ParseConfig) (BoolAst, error) {
 	if ts.Current.Type == TOKEN_LEFT_BRACKET {
+		saved := *ts
 		if !ts.Next() {
 			return nil, parseError(ErrUnexpectedEnd, ts.index)
 		}
@@ -111,7 +133,11 @@ func parseAtom(ts *TokenStream, cfg *ParseConfig) (BoolAst, error) {
 			return nil, parseError(ErrUnexpectedToken, ts.index)
 		} else {
 			ts.Next()
-			return cond, nil
+			if !isExprFollower(ts.Current.Type) {
+				return cond, nil
+			}
+			// it's a bracketed expression like (a + 1) * 2 > 3, parse it again
+			*ts = saved
 		}
 	} else if ts.Current.Type == TOKEN_NOT {
 		if !ts.Next() {
@@ -126,7 +152,7 @@ func parseAtom(ts *TokenStream, cfg *ParseConfig) (BoolAst, error) {
 			return &NOT{Child: atom}, nil
 		}
 	}
-	call, err := parseCall(ts, cfg)
+	left, err := parseExpr(ts, cfg)
 	if err != nil {
 		return nil, err
 	}
@@ -134,119 +160,659 @@ func parseAtom(ts *TokenStream, cfg *ParseConfig) (BoolAst, error) {
 	not := false
 	switch op {
 	case TOKEN_OP_EQ, TOKEN_OP_NE, TOKEN_OP_GT, TOKEN_OP_GE, TOKEN_OP_LT, TOKEN_OP_LE:
-		if typ, err := nextMustBe(ts, TOKEN_STR, TOKEN_INT, TOKEN_ID); err != nil {
+		if !ts.Next() {
+			return nil, parseError(ErrUnexpectedEnd, ts.index)
+		}
+		pos := ts.Current.Offset
+		right, err := parseExpr(ts, cfg)
+		if err != nil {
 			return nil, err
-		} else {
-			if typ == TOKEN_INT {
-				defer ts.Next()
-				return newCallThenCompare(call, op, tokenToInt(ts.Current.Text)), nil
-			} else if typ == TOKEN_STR {
-				defer ts.Next()
-				return newCallThenCompare(call, op, tokenToStr(ts.Current.Text)), nil
-			} else if call2, err := parseCall(ts, cfg); err != nil {
-				return nil, err
-			} else {
-				return &CompareWithCall{Left: call, Op: op, Right: call2}, nil
-			}
 		}
-		return nil, parseError(ErrUnexpectedToken, ts.index)
+		return newCompare(cfg, left, op, right, pos)
 	case TOKEN_NOT:
-		if _, err := nextMustBe(ts, TOKEN_OP_IN); err != nil {
+		if typ, err := nextMustBe(ts, TOKEN_OP_IN, TOKEN_OP_BETWEEN, TOKEN_OP_LIKE, TOKEN_OP_ILIKE, TOKEN_OP_GLOB,
+			TOKEN_OP_STARTS, TOKEN_OP_ENDS, TOKEN_OP_CONTAINS); err != nil {
 			return nil, err
+		} else if typ == TOKEN_OP_BETWEEN {
+			return parseBetween(ts, cfg, left, true)
+		} else if typ != TOKEN_OP_IN {
+			return parseStrMatch(ts, cfg, left, typ, true)
 		}
 		not = true
 		fallthrough
 	case TOKEN_OP_IN:
-		if typ, err := nextMustBe(ts, TOKEN_LEFT_BRACKET, TOKEN_ID); err != nil {
+		if !ts.Next() {
+			return nil, parseError(ErrUnexpectedEnd, ts.index)
+		} else if ts.Current.Type == TOKEN_LEFT_BRACKET {
+			return parseChoices(ts, cfg, left, not)
+		}
+		pos := ts.Current.Offset
+		if right, err := parseExpr(ts, cfg); err != nil {
 			return nil, err
-		} else if typ == TOKEN_ID {
-			if call2, err := parseCall(ts, cfg); err != nil {
+		} else {
+			return newInWithCall(cfg, left, right, not, pos)
+		}
+	case TOKEN_OP_LIKE, TOKEN_OP_ILIKE, TOKEN_OP_GLOB, TOKEN_OP_STARTS, TOKEN_OP_ENDS, TOKEN_OP_CONTAINS:
+		return parseStrMatch(ts, cfg, left, op, false)
+	case TOKEN_OP_MATCH, TOKEN_OP_NOT_MATCH:
+		return parseRegexpMatch(ts, cfg, left, op)
+	case TOKEN_OP_BETWEEN:
+		return parseBetween(ts, cfg, left, false)
+	case TOKEN_IS:
+		if typ, err := nextMustBe(ts, TOKEN_NOT, TOKEN_NULL); err != nil {
+			return nil, err
+		} else if typ == TOKEN_NOT {
+			if _, err := nextMustBe(ts, TOKEN_NULL); err != nil {
 				return nil, err
-			} else {
-				return &InWithCall{Left: call, Right: call2, NotIn: not}, nil
 			}
+			not = true
+		}
+		ts.Next()
+		return &IsNull{Call: left, NotNull: not}, nil
+	default:
+		return left, nil
+	}
+}
+
+// newInWithCall checks that right can be a list, pos is where it starts.
+func newInWithCall(cfg *ParseConfig, left, right Call, not bool, pos int) (BoolAst, error) {
+	if typ := cfg.typeOf(right); typ != TypeAny && typ != TypeList {
+		return nil, parseError(ErrTypeNotMatched, pos)
+	}
+	return &InWithCall{Left: left, Right: right, NotIn: not}, nil
+}
+
+// parseStrMatch parses the pattern of a string operator, the current token is
+// the operator.
+func parseStrMatch(ts *TokenStream, cfg *ParseConfig, left Call, op int, not bool) (BoolAst, error) {
+	pos := ts.Current.Offset
+	if op == TOKEN_OP_STARTS || op == TOKEN_OP_ENDS {
+		if _, err := nextMustBe(ts, TOKEN_WITH); err != nil {
+			return nil, err
+		}
+	}
+	if _, err := nextMustBe(ts, TOKEN_STR); err != nil {
+		return nil, err
+	}
+	pattern, patternPos := tokenToStr(ts.Current.Text), ts.Current.Offset
+	ts.Next()
+	return newCheckedStrMatch(cfg, left, op, pattern, not, pos, patternPos)
+}
+
+// newCheckedStrMatch checks that left can be a string, pos is where the
+// operator is and patternPos where the pattern is.
+func newCheckedStrMatch(cfg *ParseConfig, left Call, op int, pattern string, not bool,
+	pos, patternPos int) (BoolAst, error) {
+	if typ := cfg.typeOf(left); typ != TypeAny && typ != TypeStr {
+		return nil, parseError(ErrTypeNotMatched, pos)
+	}
+	if m, err := newStrMatch(left, op, pattern, not); err != nil {
+		return nil, parseError(err, patternPos)
+	} else {
+		return m, nil
+	}
+}
+
+// parseRegexpMatch parses the right side of ~ or !~. A literal pattern is
+// compiled right now, otherwise it's compiled when evaluating.
+func parseRegexpMatch(ts *TokenStream, cfg *ParseConfig, left Call, op int) (BoolAst, error) {
+	if !ts.Next() {
+		return nil, parseError(ErrUnexpectedEnd, ts.index)
+	}
+	pos := ts.Current.Offset
+	right, err := parseExpr(ts, cfg)
+	if err != nil {
+		return nil, err
+	}
+	return newRegexpMatch(cfg, left, op, right, pos)
+}
+
+// newRegexpMatch compiles a literal pattern now, a pattern from a call is
+// compiled when the query is evaluated. pos is where right starts.
+func newRegexpMatch(cfg *ParseConfig, left Call, op int, right Call, pos int) (BoolAst, error) {
+	if err := cfg.checkCompare(left, op, right, pos); err != nil {
+		return nil, err
+	}
+	lit, is := right.(*Literal)
+	if !is {
+		return &CompareWithCall{Left: left, Op: op, Right: right}, nil
+	} else if pattern, is := lit.Value.(string); !is {
+		return nil, parseError(ErrTypeNotMatched, pos)
+	} else if m, err := newStrMatch(left, TOKEN_OP_MATCH, pattern, op == TOKEN_OP_NOT_MATCH); err != nil {
+		return nil, parseError(err, pos)
+	} else {
+		return m, nil
+	}
+}
+
+// parseBetween parses the bounds of BETWEEN, the current token is BETWEEN.
+// The bounds are expressions, so the AND between them is never taken as a
+// logical AND.
+func parseBetween(ts *TokenStream, cfg *ParseConfig, left Call, not bool) (BoolAst, error) {
+	if !ts.Next() {
+		return nil, parseError(ErrUnexpectedEnd, ts.index)
+	}
+	lowerPos := ts.Current.Offset
+	lower, err := parseExpr(ts, cfg)
+	if err != nil {
+		return nil, err
+	} else if ts.Current.Type != TOKEN_AND {
+		return nil, parseError(ErrUnexpectedToken, ts.index)
+	} else if !ts.Next() {
+		return nil, parseError(ErrUnexpectedEnd, ts.index)
+	}
+	upperPos := ts.Current.Offset
+	upper, err := parseExpr(ts, cfg)
+	if err != nil {
+		return nil, err
+	}
+	exclusive := ts.Current.Type == TOKEN_EXCLUSIVE
+	if exclusive {
+		ts.Next()
+	}
+	return newBetween(cfg, left, lower, upper, exclusive, not, lowerPos, upperPos)
+}
+
+// newBetween checks that the bounds are comparable with left, lowerPos and
+// upperPos are where they start.
+func newBetween(cfg *ParseConfig, left, lower, upper Call, exclusive, not bool,
+	lowerPos, upperPos int) (BoolAst, error) {
+	if err := cfg.checkCompare(left, TOKEN_OP_GE, lower, lowerPos); err != nil {
+		return nil, err
+	} else if err := cfg.checkCompare(left, TOKEN_OP_LE, upper, upperPos); err != nil {
+		return nil, err
+	}
+	return &Between{Call: left, Lower: lower, Upper: upper, Exclusive: exclusive, NotBetween: not}, nil
+}
+
+// isExprFollower reports whether a token can only follow an expression, not a
+// condition.
+func isExprFollower(typ int) bool {
+	switch typ {
+	case TOKEN_OP_ADD, TOKEN_OP_SUB, TOKEN_OP_MUL, TOKEN_OP_DIV, TOKEN_OP_MOD,
+		TOKEN_OP_EQ, TOKEN_OP_NE, TOKEN_OP_GT, TOKEN_OP_GE, TOKEN_OP_LT, TOKEN_OP_LE,
+		TOKEN_OP_IN, TOKEN_NOT, TOKEN_IS, TOKEN_OP_LIKE, TOKEN_OP_ILIKE, TOKEN_OP_GLOB,
+		TOKEN_OP_STARTS, TOKEN_OP_ENDS, TOKEN_OP_CONTAINS, TOKEN_OP_MATCH, TOKEN_OP_NOT_MATCH,
+		TOKEN_OP_BETWEEN:
+		return true
+	}
+	return false
+}
+
+// newCompare uses the specialized compare nodes if right is a literal.
+func newCompare(cfg *ParseConfig, left Call, op int, right Call, pos int) (BoolAst, error) {
+	if err := cfg.checkCompare(left, op, right, pos); err != nil {
+		return nil, err
+	}
+	if lit, is := right.(*Literal); is {
+		switch target := lit.Value.(type) {
+		case int:
+			return newCallThenCompare(left, op, target), nil
+		case uint64:
+			return newCallThenCompare(left, op, target), nil
+		case float64:
+			return newCallThenCompare(left, op, target), nil
+		case string:
+			return newCallThenCompare(left, op, target), nil
+		}
+	}
+	return &CompareWithCall{Left: left, Op: op, Right: right}, nil
+}
+
+// parseExpr parses additions and subtractions of terms.
+func parseExpr(ts *TokenStream, cfg *ParseConfig) (Call, error) {
+	left, err := parseTerm(ts, cfg)
+	if err != nil {
+		return nil, err
+	}
+	for op := ts.Current.Type; op == TOKEN_OP_ADD || op == TOKEN_OP_SUB; op = ts.Current.Type {
+		if !ts.Next() {
+			return nil, parseError(ErrUnexpectedEnd, ts.index)
+		}
+		pos := ts.Current.Offset
+		right, err := parseTerm(ts, cfg)
+		if err != nil {
+			return nil, err
+		} else if left, err = newArith(cfg, left, op, right, pos); err != nil {
+			return nil, err
+		}
+	}
+	return left, nil
+}
+
+// parseTerm parses multiplications, divisions and modulos of primaries.
+func parseTerm(ts *TokenStream, cfg *ParseConfig) (Call, error) {
+	left, err := parsePrimary(ts, cfg)
+	if err != nil {
+		return nil, err
+	}
+	for op := ts.Current.Type; op == TOKEN_OP_MUL || op == TOKEN_OP_DIV || op == TOKEN_OP_MOD; op = ts.Current.Type {
+		if !ts.Next() {
+			return nil, parseError(ErrUnexpectedEnd, ts.index)
+		}
+		pos := ts.Current.Offset
+		right, err := parsePrimary(ts, cfg)
+		if err != nil {
+			return nil, err
+		} else if left, err = newArith(cfg, left, op, right, pos); err != nil {
+			return nil, err
+		}
+	}
+	return left, nil
+}
+
+// newArith checks the operand types of an arithmetic operation, pos is where
+// right starts.
+func newArith(cfg *ParseConfig, left Call, op int, right Call, pos int) (Call, error) {
+	if _, ok := arithType(cfg.typeOf(left), cfg.typeOf(right), op); !ok {
+		return nil, parseError(ErrTypeNotMatched, pos)
+	}
+	return &Arith{Left: left, Op: op, Right: right}, nil
+}
+
+// parsePrimary parses a literal, a call, a field or a bracketed expression.
+func parsePrimary(ts *TokenStream, cfg *ParseConfig) (Call, error) {
+	tok := ts.Current
+	if isSoftKeyword(tok.Type) {
+		return parseCall(ts, cfg)
+	}
+	switch tok.Type {
+	case TOKEN_ID:
+		saved := *ts
+		if ts.Next(); ts.Current.Type != TOKEN_LEFT_BRACKET {
+			return parseField(ts, cfg, string(tok.Text), tok.Offset)
+		}
+		*ts = saved
+		return parseCall(ts, cfg)
+	case TOKEN_LEFT_BRACKET:
+		if !ts.Next() {
+			return nil, parseError(ErrUnexpectedEnd, ts.index)
+		}
+		expr, err := parseExpr(ts, cfg)
+		if err != nil {
+			return nil, err
+		} else if ts.Current.Type != TOKEN_RIGHT_BRACKET {
+			return nil, parseError(ErrUnexpectedToken, ts.index)
+		}
+		ts.Next()
+		return expr, nil
+	case TOKEN_LEFT_SQUARE:
+		// a path whose first name isn't a plain one, like ['my name'].x
+		if _, err := nextMustBe(ts, TOKEN_STR); err != nil {
+			return nil, err
+		}
+		name := tokenToStr(ts.Current.Text)
+		if _, err := nextMustBe(ts, TOKEN_RIGHT_SQUARE); err != nil {
+			return nil, err
+		}
+		ts.Next()
+		return parseField(ts, cfg, name, tok.Offset)
+	case TOKEN_INT, TOKEN_FLOAT, TOKEN_STR, TOKEN_TRUE, TOKEN_FALSE, TOKEN_NULL:
+		v, err := literalOf(tok, TypeAny)
+		if err != nil {
+			return nil, err
+		}
+		ts.Next()
+		return &Literal{Value: v}, nil
+	case TOKEN_EOF:
+		return nil, parseError(ErrUnexpectedEnd, ts.index)
+	}
+	return nil, parseError(ErrUnexpectedToken, ts.index)
+}
+
+func parseChoices(ts *TokenStream, cfg *ParseConfig, call Call, not bool) (BoolAst, error) {
+	choiceType, err := nextMustBe(ts, TOKEN_INT, TOKEN_FLOAT, TOKEN_STR)
+	if err != nil {
+		return nil, err
+	}
+	// int and float literals can be mixed
+	expects := []int{choiceType}
+	if choiceType != TOKEN_STR {
+		expects = []int{TOKEN_INT, TOKEN_FLOAT}
+	}
+	choices := []TokenInfo{ts.Current}
+	for {
+		spType, err := nextMustBe(ts, TOKEN_COMMA, TOKEN_RIGHT_BRACKET)
+		if err != nil {
+			return nil, err
+		}
+		if spType == TOKEN_RIGHT_BRACKET {
+			break
+		}
+		if _, err := nextMustBe(ts, expects...); err != nil {
+			return nil, err
+		}
+		choices = append(choices, ts.Current)
+	}
+	ts.Next()
+	return newChoices(cfg, call, choices, not)
+}
+
+// newChoices converts the literal tokens of an IN list. Strings and numbers
+// can't be mixed, a list with a float literal is all float.
+func newChoices(cfg *ParseConfig, call Call, choices []TokenInfo, not bool) (BoolAst, error) {
+	choiceType := choices[0].Type
+	for _, choice := range choices {
+		switch {
+		case choice.Type != TOKEN_INT && choice.Type != TOKEN_FLOAT && choice.Type != TOKEN_STR,
+			(choice.Type == TOKEN_STR) != (choiceType == TOKEN_STR):
+			return nil, parseError(ErrTypeNotMatched, choice.Offset)
+		case choice.Type == TOKEN_FLOAT:
+			choiceType = TOKEN_FLOAT
+		}
+	}
+	if !comparableTypes(cfg.typeOf(call), typeOfToken(choiceType), TOKEN_OP_EQ) {
+		return nil, parseError(ErrTypeNotMatched, choices[0].Offset)
+	}
+	switch choiceType {
+	case TOKEN_INT:
+		values, err := convertChoices(choices, tokenToInt)
+		if err != nil {
+			// literals beyond the int range are still fine as uint64
+			if uvalues, uerr := convertChoices(choices, tokenToUint); uerr == nil {
+				return newCallThenInOrCompare(call, uvalues, not), nil
+			}
+			return nil, err
 		}
-		choiceType, err := nextMustBe(ts, TOKEN_INT, TOKEN_STR)
+		return newCallThenInOrCompare(call, values, not), nil
+	case TOKEN_FLOAT:
+		values, err := convertChoices(choices, tokenToFloat)
 		if err != nil {
 			return nil, err
 		}
-		choices := [][]rune{ts.Current.Text}
-		for {
-			spType, err := nextMustBe(ts, TOKEN_COMMA, TOKEN_RIGHT_BRACKET)
+		return newCallThenInOrCompare(call, values, not), nil
+	case TOKEN_STR:
+		values := make([]string, len(choices))
+		for i, choice := range choices {
+			values[i] = tokenToStr(choice.Text)
+		}
+		return newCallThenInOrCompare(call, values, not), nil
+	default:
+		panic("invalid choice type")
+	}
+}
+
+func convertChoices[T TArg](choices []TokenInfo, conv func([]rune) (T, error)) ([]T, error) {
+	values := make([]T, len(choices))
+	for i, choice := range choices {
+		v, err := conv(choice.Text)
+		if err != nil {
+			return nil, parseError(err, choice.Offset)
+		}
+		values[i] = v
+	}
+	return values, nil
+}
+
+// newCallThenInOrCompare turns an IN with a single choice into a comparison
+func newCallThenInOrCompare[T TArg](call Call, choices []T, not bool) BoolAst {
+	if len(choices) > 1 {
+		return newCallThenIn(call, choices, not)
+	} else if not {
+		return newCallThenCompare(call, TOKEN_OP_NE, choices[0])
+	} else {
+		return newCallThenCompare(call, TOKEN_OP_EQ, choices[0])
+	}
+}
+
+// parseField parses a field path starting with name at pos, the stream is
+// already after the name. The name is bound by cfg.FieldResolver if it's set.
+func parseField(ts *TokenStream, cfg *ParseConfig, name string, pos int) (Call, error) {
+	path := []PathSegment{{Name: name}}
+	for {
+		switch ts.Current.Type {
+		case TOKEN_DOT:
+			if !ts.Next() {
+				return nil, parseError(ErrUnexpectedEnd, ts.index)
+			} else if ts.Current.Type != TOKEN_ID && !isSoftKeyword(ts.Current.Type) {
+				return nil, parseError(ErrUnexpectedToken, ts.index)
+			}
+			path = append(path, PathSegment{Name: string(ts.Current.Text)})
+		case TOKEN_LEFT_SQUARE:
+			typ, err := nextMustBe(ts, TOKEN_INT, TOKEN_STR)
 			if err != nil {
 				return nil, err
 			}
-			if spType == TOKEN_RIGHT_BRACKET {
-				break
+			seg := PathSegment{IsIndex: typ == TOKEN_INT}
+			if typ == TOKEN_STR {
+				seg.Name = tokenToStr(ts.Current.Text)
+			} else if seg.Index, err = tokenToInt(ts.Current.Text); err != nil {
+				return nil, parseError(err, ts.Current.Offset)
+			}
+			if _, err := nextMustBe(ts, TOKEN_RIGHT_SQUARE); err != nil {
+				return nil, err
 			}
-			if _, err := nextMustBe(ts, choiceType); err != nil {
+			path = append(path, seg)
+		default:
+			if field, err := bindField(cfg, path, pos); err != nil {
 				return nil, err
+			} else {
+				return field, nil
 			}
-			choices = append(choices, ts.Current.Text)
 		}
 		ts.Next()
-		switch choiceType {
-		case TOKEN_INT:
-			if len(choices) == 1 {
-				if not {
-					return newCallThenCompare(call, TOKEN_OP_NE, tokenToInt(choices[0])), nil
-				} else {
-					return newCallThenCompare(call, TOKEN_OP_EQ, tokenToInt(choices[0])), nil
-				}
-			}
-			in := &In[int]{Call: call, Choices: make([]int, len(choices))}
-			for i, choice := range choices {
-				in.Choices[i] = tokenToInt(choice)
-			}
-			return newCallThenIn(in.Call, in.Choices, not), nil
-		case TOKEN_STR:
-			if len(choices) == 1 {
-				if not {
-					return newCallThenCompare(call, TOKEN_OP_NE, tokenToStr(choices[0])), nil
-				} else {
-					return newCallThenCompare(call, TOKEN_OP_EQ, tokenToStr(choices[0])), nil
-				}
-			}
-			in := &In[string]{Call: call, Choices: make([]string, len(choices))}
-			for i, choice := range choices {
-				in.Choices[i] = tokenToStr(choice)
-			}
-			return newCallThenIn(in.Call, in.Choices, not), nil
-		default:
-			panic("invalid choice type")
+	}
+}
+
+// bindField binds the whole path by cfg.pathResolver, or else the first name of
+// it by cfg.FieldResolver, if one is set. pos is where the path starts.
+func bindField(cfg *ParseConfig, path []PathSegment, pos int) (*Field, error) {
+	field := &Field{Path: path}
+	if cfg.pathResolver != nil {
+		get, err := cfg.pathResolver(path)
+		if err != nil {
+			return nil, parseError(err, pos)
 		}
-	default:
-		return call, nil
+		field.get, field.whole = get, true
+	} else if cfg.FieldResolver != nil {
+		get, err := cfg.FieldResolver(path[0].Name)
+		if err != nil {
+			return nil, parseError(err, pos)
+		}
+		field.get = get
 	}
+	return field, nil
 }
 
 func parseCall(ts *TokenStream, cfg *ParseConfig) (Call, error) {
-	if ts.Current.Type != TOKEN_ID {
+	if ts.Current.Type != TOKEN_ID && !isSoftKeyword(ts.Current.Type) {
 		return nil, parseError(ErrUnexpectedToken, ts.index)
 	}
-	name := string(ts.Current.Text)
+	name, pos := string(ts.Current.Text), ts.Current.Offset
 	if _, err := nextMustBe(ts, TOKEN_LEFT_BRACKET); err != nil {
 		return nil, err
 	}
-	var call Call
-	if typ, err := nextMustBe(ts, TOKEN_STR, TOKEN_INT); err != nil {
+	args, err := parseArgs(ts, cfg)
+	if err != nil {
 		return nil, err
-	} else {
-		switch typ {
-		case TOKEN_INT:
-			call, err = newCall(cfg.IntMethods, cfg.DefaultIntMethod, name, tokenToInt(ts.Current.Text))
-		case TOKEN_STR:
-			call, err = newCall(cfg.StrMethods, cfg.DefaultStrMethod, name, tokenToStr(ts.Current.Text))
+	}
+	ts.Next()
+	return bindCall(cfg, name, pos, args)
+}
+
+// bindCall binds a call to a method of cfg. A single argument call goes to
+// the typed maps first, then to Methods and then to the default methods.
+func bindCall(cfg *ParseConfig, name string, pos int, args []callArg) (Call, error) {
+	if len(args) == 1 {
+		if call, err := newTypedCall(cfg, name, args[0], false); err != ErrNoSuchMethod {
+			return markVolatile(cfg, name, call), err
+		}
+	}
+	if method, has := cfg.Methods[name]; has {
+		return newMethodCall(cfg, name, pos, method, args)
+	}
+	if len(args) == 1 {
+		if call, err := newTypedCall(cfg, name, args[0], true); err != ErrNoSuchMethod {
+			return markVolatile(cfg, name, call), err
 		}
+	}
+	return nil, parseError(ErrNoSuchMethod, pos)
+}
+
+// markVolatile marks a single argument call to a method of
+// cfg.NonDeterministic.
+func markVolatile(cfg *ParseConfig, name string, c Call) Call {
+	if v, is := c.(interface{ setVolatile() }); is && cfg.NonDeterministic[name] {
+		v.setVolatile()
+	}
+	return c
+}
+
+// callArg is an argument of a call, either a literal token or an expression.
+type callArg struct {
+	tok  TokenInfo
+	call Call
+	pos  int
+}
+
+// parseArgs parses the arguments of a call, it stops at the closing bracket.
+func parseArgs(ts *TokenStream, cfg *ParseConfig) ([]callArg, error) {
+	var args []callArg
+	if !ts.Next() {
+		return nil, parseError(ErrUnexpectedEnd, ts.index)
+	} else if ts.Current.Type == TOKEN_RIGHT_BRACKET {
+		return args, nil
+	}
+	for {
+		tok := ts.Current
+		expr, err := parseExpr(ts, cfg)
 		if err != nil {
 			return nil, err
 		}
+		if _, is := expr.(*Literal); is && tok.Type != TOKEN_LEFT_BRACKET {
+			// literals are converted by the param types later
+			args = append(args, callArg{tok: tok})
+		} else {
+			args = append(args, callArg{call: expr, pos: tok.Offset})
+		}
+		switch ts.Current.Type {
+		case TOKEN_RIGHT_BRACKET:
+			return args, nil
+		case TOKEN_COMMA:
+			ts.Next()
+		case TOKEN_EOF:
+			return nil, parseError(ErrUnexpectedEnd, ts.index)
+		default:
+			return nil, parseError(ErrUnexpectedToken, ts.index)
+		}
 	}
-	if _, err := nextMustBe(ts, TOKEN_RIGHT_BRACKET); err != nil {
+}
+
+// newTypedCall binds a single argument call to StrMethods, IntMethods or
+// FloatMethods by the type of the argument. A nested call is bound to the
+// first one of them having the method, and its result is checked when the
+// query is evaluated. The default methods are only used when withDefault is
+// set.
+func newTypedCall(cfg *ParseConfig, name string, arg callArg, withDefault bool) (Call, error) {
+	var (
+		defaultStr   func(string, any, string) (any, error)
+		defaultInt   func(string, any, int) (any, error)
+		defaultFloat func(string, any, float64) (any, error)
+	)
+	if withDefault {
+		defaultStr, defaultInt, defaultFloat = cfg.DefaultStrMethod, cfg.DefaultIntMethod, cfg.DefaultFloatMethod
+	}
+	if arg.call != nil {
+		if cfg.hasStrMethod(name) {
+			return newNestedCall(cfg.StrMethods, cfg.CtxStrMethods, nil, name, arg.call)
+		} else if cfg.hasIntMethod(name) {
+			return newNestedCall(cfg.IntMethods, cfg.CtxIntMethods, nil, name, arg.call)
+		} else if cfg.hasFloatMethod(name) {
+			return newNestedCall(cfg.FloatMethods, cfg.CtxFloatMethods, nil, name, arg.call)
+		} else if defaultStr != nil {
+			return newNestedCall(nil, nil, defaultStr, name, arg.call)
+		} else if defaultInt != nil {
+			return newNestedCall(nil, nil, defaultInt, name, arg.call)
+		} else if defaultFloat != nil {
+			return newNestedCall(nil, nil, defaultFloat, name, arg.call)
+		}
+		return nil, ErrNoSuchMethod
+	}
+	switch arg.tok.Type {
+	case TOKEN_INT:
+		n, err := tokenToInt(arg.tok.Text)
+		if err != nil {
+			return nil, parseError(err, arg.tok.Offset)
+		}
+		if !cfg.hasIntMethod(name) && cfg.hasFloatMethod(name) {
+			// an int literal is also accepted by float methods
+			return newCall(cfg.FloatMethods, cfg.CtxFloatMethods, nil, name, float64(n))
+		}
+		return newCall(cfg.IntMethods, cfg.CtxIntMethods, defaultInt, name, n)
+	case TOKEN_FLOAT:
+		f, err := tokenToFloat(arg.tok.Text)
+		if err != nil {
+			return nil, parseError(err, arg.tok.Offset)
+		}
+		return newCall(cfg.FloatMethods, cfg.CtxFloatMethods, defaultFloat, name, f)
+	case TOKEN_STR:
+		return newCall(cfg.StrMethods, cfg.CtxStrMethods, defaultStr, name, tokenToStr(arg.tok.Text))
+	}
+	return nil, ErrNoSuchMethod
+}
+
+func newNestedCall[T TArg](
+	fnMap map[string]func(any, T) (any, error),
+	ctxFnMap map[string]func(context.Context, any, T) (any, error),
+	defaultFn func(string, any, T) (any, error),
+	name string, argCall Call) (Call, error) {
+	c, err := newCall(fnMap, ctxFnMap, defaultFn, name, *new(T))
+	if err != nil {
 		return nil, err
 	}
-	ts.Next()
-	return call, nil
+	c.argCall = argCall
+	return c, nil
+}
+
+func newMethodCall(cfg *ParseConfig, name string, pos int, method Method, args []callArg) (Call, error) {
+	nParams, minArgs := len(method.Params), len(method.Params)
+	if method.Variadic && nParams > 0 {
+		minArgs--
+	}
+	if len(args) < minArgs || (len(args) > nParams && !method.Variadic) {
+		return nil, parseError(ErrWrongArgCount, pos)
+	}
+	exprs := make([]EvalAst, len(args))
+	for i, arg := range args {
+		if arg.call != nil {
+			if !acceptable(method.paramType(i), cfg.typeOf(arg.call)) {
+				return nil, parseError(ErrTypeNotMatched, arg.pos)
+			}
+			exprs[i] = arg.call
+		} else if v, err := literalOf(arg.tok, method.paramType(i)); err != nil {
+			return nil, err
+		} else {
+			exprs[i] = &Literal{Value: v}
+		}
+	}
+	return newFuncCall(name, method, exprs), nil
+}
+
+// literalOf converts a literal token to a value of the given type.
+func literalOf(tok TokenInfo, typ Type) (any, error) {
+	switch {
+	case tok.Type == TOKEN_STR && (typ == TypeAny || typ == TypeStr):
+		return tokenToStr(tok.Text), nil
+	case tok.Type == TOKEN_NULL && typ == TypeAny:
+		return nil, nil
+	case (tok.Type == TOKEN_TRUE || tok.Type == TOKEN_FALSE) && (typ == TypeAny || typ == TypeBool):
+		return tok.Type == TOKEN_TRUE, nil
+	case (tok.Type == TOKEN_FLOAT && typ == TypeAny) || (tok.Type == TOKEN_INT || tok.Type == TOKEN_FLOAT) && typ == TypeFloat:
+		if f, err := tokenToFloat(tok.Text); err != nil {
+			return nil, parseError(err, tok.Offset)
+		} else {
+			return f, nil
+		}
+	case tok.Type == TOKEN_INT && (typ == TypeAny || typ == TypeInt):
+		if n, err := tokenToInt(tok.Text); err == nil {
+			return n, nil
+		} else if u, uerr := tokenToUint(tok.Text); uerr == nil && typ == TypeAny {
+			return u, nil
+		} else {
+			return nil, parseError(err, tok.Offset)
+		}
+	}
+	return nil, parseError(ErrTypeNotMatched, tok.Offset)
 }
 
 func nextMustBe(ts *TokenStream, types ...int) (int, error) {
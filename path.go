@@ -0,0 +1,164 @@
+package filterql
+
+import (
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+// PathSegment is a segment of a field path, a name like city in
+// user.address.city or ['city'], or an index like 0 in tags[0].
+type PathSegment struct {
+	Name    string
+	Index   int
+	IsIndex bool
+}
+
+// pathString returns the source text of a path. A first name which is a soft
+// keyword is quoted too, since it would be parsed as a call.
+func pathString(path []PathSegment) string {
+	var sb strings.Builder
+	for i, seg := range path {
+		if seg.IsIndex {
+			sb.WriteString("[" + strconv.Itoa(seg.Index) + "]")
+		} else if isPlainName(seg.Name) && (i > 0 || !isSoftKeywordName(seg.Name)) {
+			if i > 0 {
+				sb.WriteByte('.')
+			}
+			sb.WriteString(seg.Name)
+		} else {
+			sb.WriteString("[" + quoteStr(seg.Name) + "]")
+		}
+	}
+	return sb.String()
+}
+
+// isPlainName tells if name can be written as is in a path.
+func isPlainName(name string) bool {
+	if name == "" {
+		return false
+	}
+	ts := NewTokenStream(name)
+	ts.Next()
+	if ts.Current.Type != TOKEN_ID && !isSoftKeyword(ts.Current.Type) {
+		return false
+	}
+	return !ts.Next()
+}
+
+// isSoftKeywordName tells if name is read as a soft keyword.
+func isSoftKeywordName(name string) bool {
+	ts := NewTokenStream(name)
+	ts.Next()
+	return isSoftKeyword(ts.Current.Type)
+}
+
+// lookupPath returns the value at path in v, and whether it's found. Pointers
+// and interfaces on the way are followed.
+func lookupPath(v any, path []PathSegment) (any, bool) {
+	for _, seg := range path {
+		var found bool
+		if v, found = lookupSegment(v, seg); !found {
+			return nil, false
+		}
+	}
+	if len(path) == 0 || v == nil {
+		return v, true
+	}
+	rv := reflect.ValueOf(v)
+	for rv.Kind() == reflect.Pointer {
+		if rv.IsNil() {
+			return nil, true
+		}
+		rv = rv.Elem()
+	}
+	return rv.Interface(), true
+}
+
+func lookupSegment(v any, seg PathSegment) (any, bool) {
+	// the types decoded from JSON go without reflection
+	switch c := v.(type) {
+	case map[string]any:
+		if seg.IsIndex {
+			return nil, false
+		}
+		e, found := c[seg.Name]
+		return e, found
+	case []any:
+		if seg.IsIndex && seg.Index >= 0 && seg.Index < len(c) {
+			return c[seg.Index], true
+		}
+		return nil, false
+	case nil:
+		return nil, false
+	}
+	rv := reflect.ValueOf(v)
+	for rv.Kind() == reflect.Pointer || rv.Kind() == reflect.Interface {
+		if rv.IsNil() {
+			return nil, false
+		}
+		rv = rv.Elem()
+	}
+	switch rv.Kind() {
+	case reflect.Struct:
+		if seg.IsIndex {
+			return nil, false
+		}
+		sf, found := rv.Type().FieldByName(seg.Name)
+		if !found || !sf.IsExported() {
+			return nil, false
+		}
+		// a nil embedded pointer on the way is an error
+		if f, err := rv.FieldByIndexErr(sf.Index); err == nil {
+			return f.Interface(), true
+		}
+	case reflect.Map:
+		var key reflect.Value
+		switch keyType := rv.Type().Key(); keyType.Kind() {
+		case reflect.String:
+			if !seg.IsIndex {
+				key = reflect.ValueOf(seg.Name).Convert(keyType)
+			}
+		case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+			if seg.IsIndex {
+				key = reflect.ValueOf(seg.Index).Convert(keyType)
+			}
+		}
+		if key.IsValid() {
+			if e := rv.MapIndex(key); e.IsValid() {
+				return e.Interface(), true
+			}
+		}
+	case reflect.Slice, reflect.Array:
+		if seg.IsIndex && seg.Index >= 0 && seg.Index < rv.Len() {
+			return rv.Index(seg.Index).Interface(), true
+		}
+	}
+	return nil, false
+}
+
+// parsePath parses the text of a path like user.tags[0].
+func parsePath(text string) ([]PathSegment, error) {
+	ts := NewTokenStream(text)
+	if !ts.Next() {
+		return nil, parseError(ErrUnexpectedEnd, ts.index)
+	}
+	var (
+		field Call
+		err   error
+	)
+	if tok := ts.Current; tok.Type == TOKEN_LEFT_SQUARE {
+		field, err = parsePrimary(ts, &defaultConfig)
+	} else if tok.Type == TOKEN_ID || isSoftKeyword(tok.Type) {
+		ts.Next()
+		field, err = parseField(ts, &defaultConfig, string(tok.Text), tok.Offset)
+	} else {
+		return nil, parseError(ErrUnexpectedToken, ts.index)
+	}
+	if err != nil {
+		return nil, err
+	} else if ts.Current.Type != TOKEN_EOF {
+		return nil, parseError(ErrUnexpectedToken, ts.index)
+	}
+	return field.(*Field).Path, nil
+}
@@ -0,0 +1,232 @@
+package filterql
+
+import "fmt"
+
+// Rewrite rebuilds cond bottom-up. The children of a node are rewritten
+// first, then f is called with the node, rebuilt on the new children if any
+// of them changed, and what f returns takes the place of the node. f returns
+// the node itself to keep it. The children are visited as in Walk.
+//
+// The rebuilt nodes are not type checked again as in Parse, but a child of
+// the wrong kind, like a condition where an operand is expected, fails with
+// ErrTypeNotMatched.
+func Rewrite(cond BoolAst, f func(PrintableAst) (PrintableAst, error)) (BoolAst, error) {
+	node, err := rewrite(cond, f)
+	if err != nil {
+		return nil, err
+	}
+	return asBool(node)
+}
+
+func rewrite(node PrintableAst, f func(PrintableAst) (PrintableAst, error)) (PrintableAst, error) {
+	if p, is := node.(parent); is {
+		children := p.children()
+		changed := false
+		for i, child := range children {
+			newChild, err := rewrite(child, f)
+			if err != nil {
+				return nil, err
+			} else if newChild != child {
+				children[i] = newChild
+				changed = true
+			}
+		}
+		if changed {
+			var err error
+			if node, err = p.rebuild(children); err != nil {
+				return nil, err
+			}
+		}
+	}
+	node, err := f(node)
+	if err != nil {
+		return nil, err
+	} else if node == nil {
+		return nil, fmt.Errorf("nil node: %w", ErrInvalidFilter)
+	}
+	return node, nil
+}
+
+func asBool(node PrintableAst) (BoolAst, error) {
+	if b, is := node.(BoolAst); is {
+		return b, nil
+	}
+	return nil, fmt.Errorf("%s is not a condition: %w", node, ErrTypeNotMatched)
+}
+
+func asCall(node PrintableAst) (Call, error) {
+	if c, is := node.(Call); is {
+		return c, nil
+	}
+	return nil, fmt.Errorf("%s is not an operand: %w", node, ErrTypeNotMatched)
+}
+
+func asCalls(nodes []PrintableAst) ([]Call, error) {
+	calls := make([]Call, len(nodes))
+	for i, node := range nodes {
+		var err error
+		if calls[i], err = asCall(node); err != nil {
+			return nil, err
+		}
+	}
+	return calls, nil
+}
+
+func asBools(nodes []PrintableAst) ([]BoolAst, error) {
+	list := make([]BoolAst, len(nodes))
+	for i, node := range nodes {
+		var err error
+		if list[i], err = asBool(node); err != nil {
+			return nil, err
+		}
+	}
+	return list, nil
+}
+
+func (a *ANDs) rebuild(children []PrintableAst) (PrintableAst, error) {
+	list, err := asBools(children)
+	if err != nil {
+		return nil, err
+	}
+	return &ANDs{Children: list}, nil
+}
+
+func (a *ORs) rebuild(children []PrintableAst) (PrintableAst, error) {
+	list, err := asBools(children)
+	if err != nil {
+		return nil, err
+	}
+	return &ORs{Children: list}, nil
+}
+
+func (a *NOT) rebuild(children []PrintableAst) (PrintableAst, error) {
+	child, err := asBool(children[0])
+	if err != nil {
+		return nil, err
+	}
+	return &NOT{Child: child}, nil
+}
+
+// literalArg returns the value of node if it's a literal convertible to T.
+func literalArg[T TArg](node PrintableAst) (T, bool) {
+	if lit, is := node.(*Literal); is {
+		return convertArg[T](lit.Value)
+	}
+	var zero T
+	return zero, false
+}
+
+func (c *call[T]) rebuild(children []PrintableAst) (PrintableAst, error) {
+	if arg, is := literalArg[T](children[0]); is {
+		return &call[T]{callee: c.callee, arg: arg, not: c.not}, nil
+	}
+	argCall, err := asCall(children[0])
+	if err != nil {
+		return nil, err
+	}
+	return &call[T]{callee: c.callee, argCall: argCall, not: c.not}, nil
+}
+
+func (c *funcCall) rebuild(children []PrintableAst) (PrintableAst, error) {
+	args := make([]EvalAst, len(children))
+	for i, child := range children {
+		arg, err := asCall(child)
+		if err != nil {
+			return nil, err
+		}
+		args[i] = arg
+	}
+	rebuilt := &funcCall{callee: c.callee, params: c.params, not: c.not}
+	rebuilt.setArgs(args)
+	return rebuilt, nil
+}
+
+func (a *Arith) rebuild(children []PrintableAst) (PrintableAst, error) {
+	calls, err := asCalls(children)
+	if err != nil {
+		return nil, err
+	}
+	return &Arith{Left: calls[0], Right: calls[1], Op: a.Op, not: a.not}, nil
+}
+
+func (c *IsNull) rebuild(children []PrintableAst) (PrintableAst, error) {
+	calls, err := asCalls(children)
+	if err != nil {
+		return nil, err
+	}
+	return &IsNull{Call: calls[0], NotNull: c.NotNull}, nil
+}
+
+func (c *Compare[T]) rebuild(children []PrintableAst) (PrintableAst, error) {
+	calls, err := asCalls(children)
+	if err != nil {
+		return nil, err
+	}
+	return &Compare[T]{Call: calls[0], Op: c.Op, Target: c.Target}, nil
+}
+
+func (c *StrMatch) rebuild(children []PrintableAst) (PrintableAst, error) {
+	calls, err := asCalls(children)
+	if err != nil {
+		return nil, err
+	}
+	return &StrMatch{Call: calls[0], Op: c.Op, Pattern: c.Pattern, NotMatch: c.NotMatch, re: c.re}, nil
+}
+
+func (c *Between) rebuild(children []PrintableAst) (PrintableAst, error) {
+	calls, err := asCalls(children)
+	if err != nil {
+		return nil, err
+	}
+	return &Between{Call: calls[0], Lower: calls[1], Upper: calls[2], Exclusive: c.Exclusive,
+		NotBetween: c.NotBetween}, nil
+}
+
+func (c *In[T]) rebuild(children []PrintableAst) (PrintableAst, error) {
+	calls, err := asCalls(children)
+	if err != nil {
+		return nil, err
+	}
+	return &In[T]{Call: calls[0], Choices: c.Choices, NotIn: c.NotIn}, nil
+}
+
+func (c *CompareWithCall) rebuild(children []PrintableAst) (PrintableAst, error) {
+	calls, err := asCalls(children)
+	if err != nil {
+		return nil, err
+	}
+	return &CompareWithCall{Left: calls[0], Right: calls[1], Op: c.Op}, nil
+}
+
+func (c *InWithCall) rebuild(children []PrintableAst) (PrintableAst, error) {
+	calls, err := asCalls(children)
+	if err != nil {
+		return nil, err
+	}
+	return &InWithCall{Left: calls[0], Right: calls[1], NotIn: c.NotIn}, nil
+}
+
+// A merged call with a new argument which isn't a literal is split again
+// into the call and the comparison.
+
+func (c *callThenCompare[T1, T2]) rebuild(children []PrintableAst) (PrintableAst, error) {
+	if arg, is := literalArg[T1](children[0]); is {
+		return &callThenCompare[T1, T2]{callee: c.callee, arg: arg, target: c.target, op: c.op}, nil
+	}
+	argCall, err := asCall(children[0])
+	if err != nil {
+		return nil, err
+	}
+	return &Compare[T2]{Call: &call[T1]{callee: c.callee, argCall: argCall}, Op: c.op, Target: c.target}, nil
+}
+
+func (c *callThenIn[T1, T2]) rebuild(children []PrintableAst) (PrintableAst, error) {
+	if arg, is := literalArg[T1](children[0]); is {
+		return &callThenIn[T1, T2]{callee: c.callee, arg: arg, choices: c.choices, not: c.not}, nil
+	}
+	argCall, err := asCall(children[0])
+	if err != nil {
+		return nil, err
+	}
+	return &In[T2]{Call: &call[T1]{callee: c.callee, argCall: argCall}, Choices: c.choices, NotIn: c.not}, nil
+}
@@ -2,6 +2,7 @@ package filterql
 
 import (
 	"fmt"
+	"strconv"
 	"strings"
 	"unicode"
 )
@@ -25,6 +26,31 @@ const (
 	TOKEN_OP_LE
 	TOKEN_OP_IN
 	TOKEN_EOF
+	// the tokens added later, the values of the ones above are kept
+	TOKEN_FLOAT
+	TOKEN_TRUE
+	TOKEN_FALSE
+	TOKEN_NULL
+	TOKEN_IS
+	TOKEN_OP_ADD
+	TOKEN_OP_SUB
+	TOKEN_OP_MUL
+	TOKEN_OP_DIV
+	TOKEN_OP_MOD
+	TOKEN_OP_LIKE
+	TOKEN_OP_ILIKE
+	TOKEN_OP_GLOB
+	TOKEN_OP_STARTS
+	TOKEN_OP_ENDS
+	TOKEN_OP_CONTAINS
+	TOKEN_WITH
+	TOKEN_OP_MATCH
+	TOKEN_OP_NOT_MATCH
+	TOKEN_OP_BETWEEN
+	TOKEN_EXCLUSIVE
+	TOKEN_DOT
+	TOKEN_LEFT_SQUARE
+	TOKEN_RIGHT_SQUARE
 )
 
 func tokenName(t int) string {
@@ -33,14 +59,24 @@ func tokenName(t int) string {
 		return "TOKEN_NONE"
 	case TOKEN_INT:
 		return "TOKEN_INT"
+	case TOKEN_FLOAT:
+		return "TOKEN_FLOAT"
 	case TOKEN_STR:
 		return "TOKEN_STR"
+	case TOKEN_TRUE:
+		return "TOKEN_TRUE"
+	case TOKEN_FALSE:
+		return "TOKEN_FALSE"
+	case TOKEN_NULL:
+		return "TOKEN_NULL"
 	case TOKEN_AND:
 		return "TOKEN_AND"
 	case TOKEN_OR:
 		return "TOKEN_OR"
 	case TOKEN_NOT:
 		return "TOKEN_NOT"
+	case TOKEN_IS:
+		return "TOKEN_IS"
 	case TOKEN_ID:
 		return "TOKEN_ID"
 	case TOKEN_LEFT_BRACKET:
@@ -63,6 +99,44 @@ func tokenName(t int) string {
 		return "TOKEN_OP_LE"
 	case TOKEN_OP_IN:
 		return "TOKEN_OP_IN"
+	case TOKEN_OP_ADD:
+		return "TOKEN_OP_ADD"
+	case TOKEN_OP_SUB:
+		return "TOKEN_OP_SUB"
+	case TOKEN_OP_MUL:
+		return "TOKEN_OP_MUL"
+	case TOKEN_OP_DIV:
+		return "TOKEN_OP_DIV"
+	case TOKEN_OP_MOD:
+		return "TOKEN_OP_MOD"
+	case TOKEN_OP_LIKE:
+		return "TOKEN_OP_LIKE"
+	case TOKEN_OP_ILIKE:
+		return "TOKEN_OP_ILIKE"
+	case TOKEN_OP_GLOB:
+		return "TOKEN_OP_GLOB"
+	case TOKEN_OP_STARTS:
+		return "TOKEN_OP_STARTS"
+	case TOKEN_OP_ENDS:
+		return "TOKEN_OP_ENDS"
+	case TOKEN_OP_CONTAINS:
+		return "TOKEN_OP_CONTAINS"
+	case TOKEN_WITH:
+		return "TOKEN_WITH"
+	case TOKEN_OP_MATCH:
+		return "TOKEN_OP_MATCH"
+	case TOKEN_OP_NOT_MATCH:
+		return "TOKEN_OP_NOT_MATCH"
+	case TOKEN_OP_BETWEEN:
+		return "TOKEN_OP_BETWEEN"
+	case TOKEN_EXCLUSIVE:
+		return "TOKEN_EXCLUSIVE"
+	case TOKEN_DOT:
+		return "TOKEN_DOT"
+	case TOKEN_LEFT_SQUARE:
+		return "TOKEN_LEFT_SQUARE"
+	case TOKEN_RIGHT_SQUARE:
+		return "TOKEN_RIGHT_SQUARE"
 	case TOKEN_EOF:
 		return "TOKEN_EOF"
 	default:
@@ -101,6 +175,12 @@ func (ts *TokenStream) Next() bool {
 	}
 	begin := ts.index
 	ch := ts.ch()
+	// a sign right after an operand is an operator, otherwise it belongs to
+	// a number
+	if (ch == '-' || ch == '+') && ts.isDigitAt(ts.index+1) && !ts.afterOperand() {
+		ts.index++
+		return ts.nextNumber(begin)
+	}
 	if ts.nextSimple(begin, ch) {
 		return true
 	}
@@ -111,8 +191,10 @@ func (ts *TokenStream) Next() bool {
 		return ts.nextStr(begin)
 	}
 	if ch >= '0' && ch <= '9' {
-		return ts.nextInt(begin)
+		return ts.nextNumber(begin)
 	}
+	ts.index++
+	ts.setCurrent(TOKEN_NONE, begin)
 	return true
 }
 
@@ -133,6 +215,37 @@ func (ts *TokenStream) nextSimple(begin int, ch rune) bool {
 		ts.index++
 		ts.setCurrent(TOKEN_OP_EQ, begin)
 		return true
+	case '+':
+		ts.index++
+		ts.setCurrent(TOKEN_OP_ADD, begin)
+		return true
+	case '-':
+		ts.index++
+		ts.setCurrent(TOKEN_OP_SUB, begin)
+		return true
+	case '*':
+		ts.index++
+		ts.setCurrent(TOKEN_OP_MUL, begin)
+		return true
+	case '/':
+		ts.index++
+		ts.setCurrent(TOKEN_OP_DIV, begin)
+		return true
+	case '%':
+		ts.index++
+		ts.setCurrent(TOKEN_OP_MOD, begin)
+		return true
+	case '~':
+		ts.index++
+		ts.setCurrent(TOKEN_OP_MATCH, begin)
+		return true
+	case '!':
+		if !ts.isAt(ts.index+1, '~') {
+			return false
+		}
+		ts.index += 2
+		ts.setCurrent(TOKEN_OP_NOT_MATCH, begin)
+		return true
 	case '(':
 		ts.index++
 		ts.setCurrent(TOKEN_LEFT_BRACKET, begin)
@@ -145,6 +258,18 @@ func (ts *TokenStream) nextSimple(begin int, ch rune) bool {
 		ts.index++
 		ts.setCurrent(TOKEN_COMMA, begin)
 		return true
+	case '.':
+		ts.index++
+		ts.setCurrent(TOKEN_DOT, begin)
+		return true
+	case '[':
+		ts.index++
+		ts.setCurrent(TOKEN_LEFT_SQUARE, begin)
+		return true
+	case ']':
+		ts.index++
+		ts.setCurrent(TOKEN_RIGHT_SQUARE, begin)
+		return true
 	case '<':
 		ts.index++
 		if ts.reachEnd() {
@@ -168,57 +293,47 @@ func (ts *TokenStream) nextSimple(begin int, ch rune) bool {
 			ts.setCurrent(TOKEN_OP_GT, begin)
 		}
 		return true
-	case 'A', 'a':
-		ts.index++
-		if ch := ts.ch(); ch != 'N' && ch != 'n' {
-			return ts.nextID(begin)
-		}
-		ts.index++
-		if ch := ts.ch(); ch != 'D' && ch != 'd' {
-			return ts.nextID(begin)
-		}
-		ts.index++
-		if ch := ts.ch(); ts.isIdChars(ch, false) {
-			return ts.nextID(begin)
-		}
-		ts.setCurrent(TOKEN_AND, begin)
-		return true
-	case 'O', 'o':
-		ts.index++
-		if ch := ts.ch(); ch != 'R' && ch != 'r' {
-			return ts.nextID(begin)
-		}
-		ts.index++
-		if ch := ts.ch(); ts.isIdChars(ch, false) {
-			return ts.nextID(begin)
-		}
-		ts.setCurrent(TOKEN_OR, begin)
-		return true
-	case 'I', 'i':
-		ts.index++
-		if ch := ts.ch(); ch != 'N' && ch != 'n' {
-			return ts.nextID(begin)
-		}
-		ts.index++
-		if ch := ts.ch(); ts.isIdChars(ch, false) {
-			return ts.nextID(begin)
-		}
-		ts.setCurrent(TOKEN_OP_IN, begin)
+	}
+	return false
+}
+
+var keywords = map[string]int{
+	"and":   TOKEN_AND,
+	"or":    TOKEN_OR,
+	"not":   TOKEN_NOT,
+	"in":    TOKEN_OP_IN,
+	"is":    TOKEN_IS,
+	"null":  TOKEN_NULL,
+	"true":  TOKEN_TRUE,
+	"false": TOKEN_FALSE,
+	// the following are only keywords after an operand, see isSoftKeyword
+	"like":      TOKEN_OP_LIKE,
+	"ilike":     TOKEN_OP_ILIKE,
+	"glob":      TOKEN_OP_GLOB,
+	"starts":    TOKEN_OP_STARTS,
+	"ends":      TOKEN_OP_ENDS,
+	"contains":  TOKEN_OP_CONTAINS,
+	"with":      TOKEN_WITH,
+	"between":   TOKEN_OP_BETWEEN,
+	"exclusive": TOKEN_EXCLUSIVE,
+}
+
+// isSoftKeyword reports whether a keyword can still be used as a method name,
+// so that existing methods like contains() keep working.
+func isSoftKeyword(typ int) bool {
+	switch typ {
+	case TOKEN_OP_LIKE, TOKEN_OP_ILIKE, TOKEN_OP_GLOB, TOKEN_OP_STARTS, TOKEN_OP_ENDS, TOKEN_OP_CONTAINS, TOKEN_WITH,
+		TOKEN_OP_BETWEEN, TOKEN_EXCLUSIVE:
 		return true
-	case 'N', 'n':
-		ts.index++
-		if ch := ts.ch(); ch != 'O' && ch != 'o' {
-			return ts.nextID(begin)
-		}
-		ts.index++
-		if ch := ts.ch(); ch != 'T' && ch != 't' {
-			return ts.nextID(begin)
-		}
-		ts.index++
-		if ch := ts.ch(); ts.isIdChars(ch, false) {
-			return ts.nextID(begin)
-		}
-		ts.setCurrent(TOKEN_NOT, begin)
+	}
+	return false
+}
+
+// afterOperand reports whether the current token ends an operand.
+func (ts *TokenStream) afterOperand() bool {
+	switch ts.Current.Type {
+	case TOKEN_INT, TOKEN_FLOAT, TOKEN_STR, TOKEN_TRUE, TOKEN_FALSE, TOKEN_NULL, TOKEN_ID, TOKEN_RIGHT_BRACKET,
+		TOKEN_RIGHT_SQUARE:
 		return true
 	}
 	return false
@@ -228,7 +343,11 @@ func (ts *TokenStream) nextID(begin int) bool {
 	for ts.isIdChars(ts.ch(), false) {
 		ts.index++
 	}
-	ts.setCurrent(TOKEN_ID, begin)
+	if typ, is := keywords[strings.ToLower(string(ts.getText(begin)))]; is {
+		ts.setCurrent(typ, begin)
+	} else {
+		ts.setCurrent(TOKEN_ID, begin)
+	}
 	return true
 }
 
@@ -248,18 +367,43 @@ func (ts *TokenStream) isIdChars(ch rune, canBegin bool) bool {
 	return false
 }
 
-func (ts *TokenStream) nextInt(begin int) bool {
-	for {
+func (ts *TokenStream) nextNumber(begin int) bool {
+	typ := TOKEN_INT
+	ts.skipDigits()
+	if ts.ch() == '.' && ts.isDigitAt(ts.index+1) {
+		typ = TOKEN_FLOAT
 		ts.index++
-		ch := ts.ch()
-		if ch < '0' || ch > '9' {
-			break
+		ts.skipDigits()
+	}
+	if ch := ts.ch(); ch == 'e' || ch == 'E' {
+		next := ts.index + 1
+		if next < len(ts.chars) && (ts.chars[next] == '+' || ts.chars[next] == '-') {
+			next++
+		}
+		if ts.isDigitAt(next) {
+			typ = TOKEN_FLOAT
+			ts.index = next
+			ts.skipDigits()
 		}
 	}
-	ts.setCurrent(TOKEN_INT, begin)
+	ts.setCurrent(typ, begin)
 	return true
 }
 
+func (ts *TokenStream) skipDigits() {
+	for ts.isDigitAt(ts.index) {
+		ts.index++
+	}
+}
+
+func (ts *TokenStream) isAt(index int, ch rune) bool {
+	return index < len(ts.chars) && ts.chars[index] == ch
+}
+
+func (ts *TokenStream) isDigitAt(index int) bool {
+	return index < len(ts.chars) && ts.chars[index] >= '0' && ts.chars[index] <= '9'
+}
+
 func (ts *TokenStream) nextStr(begin int) bool {
 	escaping := false
 	for !ts.reachEnd() {
@@ -296,6 +440,13 @@ func (ts *TokenStream) skipSpaces() {
 	}
 }
 
+var strEscaper = strings.NewReplacer(`\`, `\\`, `'`, `\'`, "\t", `\t`, "\n", `\n`, "\r", `\r`)
+
+// quoteStr returns the string literal of s, the reverse of tokenToStr.
+func quoteStr(s string) string {
+	return "'" + strEscaper.Replace(s) + "'"
+}
+
 func tokenToStr(text []rune) string {
 	text = text[1 : len(text)-1] // 去掉引号
 	var b strings.Builder
@@ -326,27 +477,29 @@ func tokenToStr(text []rune) string {
 	return b.String()
 }
 
-func tokenToInt(text []rune) int {
-	n := len(text)
-	if n < 1 {
-		panic("invalid int literal")
+func tokenToInt(text []rune) (int, error) {
+	n, err := strconv.ParseInt(string(text), 10, strconv.IntSize)
+	if err != nil {
+		return 0, ErrNumberOutOfRange
 	}
-	negative := false
-	i := 0
-	if text[0] == '-' {
-		negative = true
-		i++
-	} else if text[0] == '+' {
-		i++
+	return int(n), nil
+}
+
+func tokenToUint(text []rune) (uint64, error) {
+	if len(text) > 0 && text[0] == '+' {
+		text = text[1:]
 	}
-	r := 0
-	for i < n {
-		ch := text[i]
-		i++
-		r = r*10 + (int(ch) - int('0'))
+	n, err := strconv.ParseUint(string(text), 10, 64)
+	if err != nil {
+		return 0, ErrNumberOutOfRange
 	}
-	if negative {
-		return -r
+	return n, nil
+}
+
+func tokenToFloat(text []rune) (float64, error) {
+	f, err := strconv.ParseFloat(string(text), 64)
+	if err != nil {
+		return 0, ErrNumberOutOfRange
 	}
-	return r
+	return f, nil
 }
@@ -45,4 +45,129 @@ func TestGetToken(t *testing.T) {
 		fql.TOKEN_OP_LT,
 		fql.TOKEN_INT,
 	)
+	assertTokens(t, "score(1.5) >= 2.25e3 or 1e-3 < 0.5",
+		fql.TOKEN_ID,
+		fql.TOKEN_LEFT_BRACKET,
+		fql.TOKEN_FLOAT,
+		fql.TOKEN_RIGHT_BRACKET,
+		fql.TOKEN_OP_GE,
+		fql.TOKEN_FLOAT,
+		fql.TOKEN_OR,
+		fql.TOKEN_FLOAT,
+		fql.TOKEN_OP_LT,
+		fql.TOKEN_FLOAT,
+	)
+	assertTokens(t, "rec(-1) in (-5, +3.5)",
+		fql.TOKEN_ID,
+		fql.TOKEN_LEFT_BRACKET,
+		fql.TOKEN_INT,
+		fql.TOKEN_RIGHT_BRACKET,
+		fql.TOKEN_OP_IN,
+		fql.TOKEN_LEFT_BRACKET,
+		fql.TOKEN_INT,
+		fql.TOKEN_COMMA,
+		fql.TOKEN_FLOAT,
+		fql.TOKEN_RIGHT_BRACKET,
+	)
+	assertTokens(t, "flag('beta') = True or user('email') IS not Null and isnull(False)",
+		fql.TOKEN_ID,
+		fql.TOKEN_LEFT_BRACKET,
+		fql.TOKEN_STR,
+		fql.TOKEN_RIGHT_BRACKET,
+		fql.TOKEN_OP_EQ,
+		fql.TOKEN_TRUE,
+		fql.TOKEN_OR,
+		fql.TOKEN_ID,
+		fql.TOKEN_LEFT_BRACKET,
+		fql.TOKEN_STR,
+		fql.TOKEN_RIGHT_BRACKET,
+		fql.TOKEN_IS,
+		fql.TOKEN_NOT,
+		fql.TOKEN_NULL,
+		fql.TOKEN_AND,
+		fql.TOKEN_ID,
+		fql.TOKEN_LEFT_BRACKET,
+		fql.TOKEN_FALSE,
+		fql.TOKEN_RIGHT_BRACKET,
+	)
+	assertTokens(t, "a(1)-1 -1 * (2 % -3) / +4",
+		fql.TOKEN_ID,
+		fql.TOKEN_LEFT_BRACKET,
+		fql.TOKEN_INT,
+		fql.TOKEN_RIGHT_BRACKET,
+		fql.TOKEN_OP_SUB,
+		fql.TOKEN_INT,
+		fql.TOKEN_OP_SUB,
+		fql.TOKEN_INT,
+		fql.TOKEN_OP_MUL,
+		fql.TOKEN_LEFT_BRACKET,
+		fql.TOKEN_INT,
+		fql.TOKEN_OP_MOD,
+		fql.TOKEN_INT,
+		fql.TOKEN_RIGHT_BRACKET,
+		fql.TOKEN_OP_DIV,
+		fql.TOKEN_INT,
+	)
+	assertTokens(t, "name() Starts With 'a' or name() not LIKE 'b%' and contains('c')",
+		fql.TOKEN_ID,
+		fql.TOKEN_LEFT_BRACKET,
+		fql.TOKEN_RIGHT_BRACKET,
+		fql.TOKEN_OP_STARTS,
+		fql.TOKEN_WITH,
+		fql.TOKEN_STR,
+		fql.TOKEN_OR,
+		fql.TOKEN_ID,
+		fql.TOKEN_LEFT_BRACKET,
+		fql.TOKEN_RIGHT_BRACKET,
+		fql.TOKEN_NOT,
+		fql.TOKEN_OP_LIKE,
+		fql.TOKEN_STR,
+		fql.TOKEN_AND,
+		fql.TOKEN_OP_CONTAINS,
+		fql.TOKEN_LEFT_BRACKET,
+		fql.TOKEN_STR,
+		fql.TOKEN_RIGHT_BRACKET,
+	)
+	assertTokens(t, "ua() ~ '^curl/' and ua()!~'wget'",
+		fql.TOKEN_ID,
+		fql.TOKEN_LEFT_BRACKET,
+		fql.TOKEN_RIGHT_BRACKET,
+		fql.TOKEN_OP_MATCH,
+		fql.TOKEN_STR,
+		fql.TOKEN_AND,
+		fql.TOKEN_ID,
+		fql.TOKEN_LEFT_BRACKET,
+		fql.TOKEN_RIGHT_BRACKET,
+		fql.TOKEN_OP_NOT_MATCH,
+		fql.TOKEN_STR,
+	)
+	assertTokens(t, "user.tags[-1] = a['b'] - 1",
+		fql.TOKEN_ID,
+		fql.TOKEN_DOT,
+		fql.TOKEN_ID,
+		fql.TOKEN_LEFT_SQUARE,
+		fql.TOKEN_INT,
+		fql.TOKEN_RIGHT_SQUARE,
+		fql.TOKEN_OP_EQ,
+		fql.TOKEN_ID,
+		fql.TOKEN_LEFT_SQUARE,
+		fql.TOKEN_STR,
+		fql.TOKEN_RIGHT_SQUARE,
+		fql.TOKEN_OP_SUB,
+		fql.TOKEN_INT,
+	)
+}
+
+func TestTokenValues(t *testing.T) {
+	// the values of the first tokens are kept as they were
+	for expected, tok := range []int{
+		fql.TOKEN_NONE, fql.TOKEN_INT, fql.TOKEN_STR, fql.TOKEN_AND, fql.TOKEN_OR,
+		fql.TOKEN_NOT, fql.TOKEN_ID, fql.TOKEN_LEFT_BRACKET, fql.TOKEN_RIGHT_BRACKET,
+		fql.TOKEN_COMMA, fql.TOKEN_OP_EQ, fql.TOKEN_OP_NE, fql.TOKEN_OP_GT, fql.TOKEN_OP_GE,
+		fql.TOKEN_OP_LT, fql.TOKEN_OP_LE, fql.TOKEN_OP_IN, fql.TOKEN_EOF,
+	} {
+		if tok != expected {
+			t.Errorf("expected token %d but got %d", expected, tok)
+		}
+	}
 }
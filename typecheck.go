@@ -0,0 +1,117 @@
+package filterql
+
+// The checks below run while the query is parsed, against the result types
+// declared in ParseConfig. A value of TypeAny is never rejected, so they only
+// catch what would fail for every env.
+
+// typeOfValue returns the type of a literal value.
+func typeOfValue(v any) Type {
+	switch v.(type) {
+	case int, uint64:
+		return TypeInt
+	case float64:
+		return TypeFloat
+	case string:
+		return TypeStr
+	case bool:
+		return TypeBool
+	}
+	return TypeAny
+}
+
+// typeOf returns the type of the result of c as far as it's known.
+func (cfg *ParseConfig) typeOf(c Call) Type {
+	switch c := c.(type) {
+	case *Literal:
+		return typeOfValue(c.Value)
+	case *funcCall:
+		return cfg.Methods[c.name].Returns
+	case *Arith:
+		typ, _ := arithType(cfg.typeOf(c.Left), cfg.typeOf(c.Right), c.Op)
+		return typ
+	case interface{ methodName() string }:
+		return cfg.ReturnTypes[c.methodName()]
+	}
+	return TypeAny
+}
+
+func (c *callee[T]) methodName() string {
+	return c.name
+}
+
+func isNumeric(typ Type) bool {
+	return typ == TypeInt || typ == TypeFloat
+}
+
+// comparableTypes tells if values of types a and b can be compared by op.
+func comparableTypes(a, b Type, op int) bool {
+	if op == TOKEN_OP_MATCH || op == TOKEN_OP_NOT_MATCH {
+		return (a == TypeAny || a == TypeStr) && (b == TypeAny || b == TypeStr)
+	}
+	switch {
+	case (a == TypeBool || b == TypeBool) && op != TOKEN_OP_EQ && op != TOKEN_OP_NE:
+		// bools are only comparable for equality
+		return false
+	case a == TypeAny || b == TypeAny:
+		return true
+	case a == TypeList || b == TypeList:
+		return false
+	case isNumeric(a) && isNumeric(b):
+		return true
+	}
+	return a == b
+}
+
+// arithType returns the result type of an arithmetic operation, and false if
+// the operand types don't fit it.
+func arithType(a, b Type, op int) (Type, bool) {
+	switch {
+	case a == TypeStr && b == TypeStr:
+		return TypeStr, op == TOKEN_OP_ADD
+	case a == TypeInt && b == TypeInt:
+		return TypeInt, true
+	case isNumeric(a) && isNumeric(b):
+		return TypeFloat, true
+	case a == TypeAny && (b == TypeAny || isNumeric(b) || b == TypeStr):
+		return TypeAny, true
+	case b == TypeAny && (isNumeric(a) || a == TypeStr):
+		return TypeAny, true
+	}
+	return TypeAny, false
+}
+
+// acceptable tells if a value of type typ can be passed as a param of type
+// param.
+func acceptable(param, typ Type) bool {
+	switch {
+	case param == TypeAny || typ == TypeAny || param == typ:
+		return true
+	case param == TypeFloat:
+		return typ == TypeInt
+	}
+	return false
+}
+
+// checkCompare checks that left and right can be compared by op, pos is where
+// right starts.
+func (cfg *ParseConfig) checkCompare(left Call, op int, right Call, pos int) error {
+	if !comparableTypes(cfg.typeOf(left), cfg.typeOf(right), op) {
+		return parseError(ErrTypeNotMatched, pos)
+	}
+	return nil
+}
+
+// typeOfToken returns the type of a literal token.
+func typeOfToken(typ int) Type {
+	switch typ {
+	case TOKEN_INT:
+		return TypeInt
+	case TOKEN_FLOAT:
+		return TypeFloat
+	case TOKEN_STR:
+		return TypeStr
+	case TOKEN_TRUE, TOKEN_FALSE:
+		return TypeBool
+	}
+	return TypeAny
+}
@@ -0,0 +1,34 @@
+package filterql
+
+// Type is the type of a method param or result.
+type Type int
+
+const (
+	TypeAny Type = iota
+	TypeInt
+	TypeFloat
+	TypeStr
+	TypeBool
+	// TypeList is a slice or array, it's only a method result type and the
+	// right side of IN.
+	TypeList
+)
+
+func (t Type) String() string {
+	switch t {
+	case TypeAny:
+		return "any"
+	case TypeInt:
+		return "int"
+	case TypeFloat:
+		return "float"
+	case TypeStr:
+		return "string"
+	case TypeBool:
+		return "bool"
+	case TypeList:
+		return "list"
+	default:
+		return "unknown type"
+	}
+}
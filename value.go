@@ -0,0 +1,430 @@
+package filterql
+
+import (
+	"math"
+	"reflect"
+	"strings"
+	"unicode/utf8"
+)
+
+type ordered interface {
+	~int | ~int64 | ~uint64 | ~float64 | ~string
+}
+
+const (
+	numInt  = iota + 1 // fits in int64
+	numUint            // uint64 beyond the int64 range
+	numFloat
+)
+
+// number is a method result or literal normalized from any Go numeric type.
+type number struct {
+	kind int
+	i    int64
+	u    uint64
+	f    float64
+}
+
+func fromUint(u uint64) number {
+	if u > math.MaxInt64 {
+		return number{kind: numUint, u: u}
+	}
+	return number{kind: numInt, i: int64(u)}
+}
+
+func toNumber(v any) (number, bool) {
+	switch n := v.(type) {
+	case int:
+		return number{kind: numInt, i: int64(n)}, true
+	case int64:
+		return number{kind: numInt, i: n}, true
+	case int32:
+		return number{kind: numInt, i: int64(n)}, true
+	case int16:
+		return number{kind: numInt, i: int64(n)}, true
+	case int8:
+		return number{kind: numInt, i: int64(n)}, true
+	case uint:
+		return fromUint(uint64(n)), true
+	case uint64:
+		return fromUint(n), true
+	case uint32:
+		return number{kind: numInt, i: int64(n)}, true
+	case uint16:
+		return number{kind: numInt, i: int64(n)}, true
+	case uint8:
+		return number{kind: numInt, i: int64(n)}, true
+	case uintptr:
+		return fromUint(uint64(n)), true
+	case float64:
+		return number{kind: numFloat, f: n}, true
+	case float32:
+		return number{kind: numFloat, f: float64(n)}, true
+	case nil, string, bool:
+		return number{}, false
+	}
+	// named types such as `type Level int`
+	rv := reflect.ValueOf(v)
+	switch rv.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return number{kind: numInt, i: rv.Int()}, true
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return fromUint(rv.Uint()), true
+	case reflect.Float32, reflect.Float64:
+		return number{kind: numFloat, f: rv.Float()}, true
+	}
+	return number{}, false
+}
+
+func (n number) float() float64 {
+	switch n.kind {
+	case numInt:
+		return float64(n.i)
+	case numUint:
+		return float64(n.u)
+	default:
+		return n.f
+	}
+}
+
+func compareNumbers(a, b number, op int) bool {
+	switch {
+	case a.kind == numFloat || b.kind == numFloat:
+		return compareByOp(a.float(), b.float(), op)
+	case a.kind == numInt && b.kind == numInt:
+		return compareByOp(a.i, b.i, op)
+	case a.kind == numUint && b.kind == numUint:
+		return compareByOp(a.u, b.u, op)
+	case a.kind == numInt:
+		// b is beyond the int64 range, so it's always the greater one
+		return compareByOp(0, 1, op)
+	default:
+		return compareByOp(1, 0, op)
+	}
+}
+
+// isNull reports whether v is nil or a nil pointer, map, slice etc.
+func isNull(v any) bool {
+	if v == nil {
+		return true
+	}
+	switch rv := reflect.ValueOf(v); rv.Kind() {
+	case reflect.Pointer, reflect.Map, reflect.Slice, reflect.Interface, reflect.Func, reflect.Chan:
+		return rv.IsNil()
+	}
+	return false
+}
+
+// isTruthy is how a value is interpreted when it's used as a condition.
+func isTruthy(v any) bool {
+	switch result := v.(type) {
+	case int:
+		return result != 0
+	case float64:
+		return result != 0
+	case string:
+		return result != ""
+	case bool:
+		return result
+	case nil:
+		return false
+	default:
+		return !reflect.ValueOf(result).IsZero()
+	}
+}
+
+// convertArg converts the result of a nested call to the argument type of
+// a single argument method.
+func convertArg[T TArg](v any) (T, bool) {
+	if arg, is := v.(T); is {
+		return arg, true
+	}
+	var arg T
+	n, isNum := toNumber(v)
+	switch p := any(&arg).(type) {
+	case *int:
+		if isNum && n.kind == numInt && int64(int(n.i)) == n.i {
+			*p = int(n.i)
+			return arg, true
+		}
+	case *float64:
+		if isNum {
+			*p = n.float()
+			return arg, true
+		}
+	}
+	return arg, false
+}
+
+// convertTo converts the result of a nested call to the param type of a
+// Method.
+func convertTo(v any, typ Type) (any, error) {
+	var ok bool
+	switch typ {
+	case TypeInt:
+		v, ok = convertArg[int](v)
+	case TypeFloat:
+		v, ok = convertArg[float64](v)
+	case TypeStr:
+		v, ok = convertArg[string](v)
+	case TypeBool:
+		_, ok = v.(bool)
+	default:
+		ok = true
+	}
+	if !ok {
+		return nil, ErrTypeNotMatched
+	}
+	return v, nil
+}
+
+// toFloat converts a numeric method result to float64.
+func toFloat(v any) (float64, bool) {
+	if n, is := toNumber(v); is {
+		return n.float(), true
+	}
+	return 0, false
+}
+
+// compareValues compares two values of any supported type. Strings only
+// compare with strings and bools only with bools for equality, while integers
+// of any size and signedness compare exactly with each other and any other mix
+// of numbers is compared as float64.
+func compareValues(a, b any, op int) (bool, error) {
+	switch v1 := a.(type) {
+	case int:
+		if v2, is := b.(int); is {
+			return compareByOp(v1, v2, op), nil
+		}
+	case string:
+		if v2, is := b.(string); is {
+			return compareByOp(v1, v2, op), nil
+		}
+		return false, ErrTypeNotMatched
+	case bool:
+		if v2, is := b.(bool); is {
+			switch op {
+			case TOKEN_OP_EQ:
+				return v1 == v2, nil
+			case TOKEN_OP_NE:
+				return v1 != v2, nil
+			}
+		}
+		return false, ErrTypeNotMatched
+	}
+	if v1, is := toNumber(a); is {
+		if v2, is := toNumber(b); is {
+			return compareNumbers(v1, v2, op), nil
+		}
+	}
+	return false, ErrTypeNotMatched
+}
+
+// inValues is inSlice for a value whose type isn't known in advance.
+func inValues[T TArg](v any, choices []T) (bool, error) {
+	if val, is := v.(T); is {
+		return inSlice(val, choices), nil
+	}
+	for _, choice := range choices {
+		if eq, err := compareValues(v, choice, TOKEN_OP_EQ); err != nil {
+			return false, err
+		} else if eq {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// inList checks v against a list returned by a method.
+func inList(v any, list any) (bool, error) {
+	switch l := list.(type) {
+	case []int:
+		return inValues(v, l)
+	case []string:
+		return inValues(v, l)
+	case []float64:
+		return inValues(v, l)
+	}
+	rv := reflect.ValueOf(list)
+	if kind := rv.Kind(); kind != reflect.Slice && kind != reflect.Array {
+		return false, ErrTypeNotMatched
+	}
+	for i, n := 0, rv.Len(); i < n; i++ {
+		if eq, err := compareValues(v, rv.Index(i).Interface(), TOKEN_OP_EQ); err != nil {
+			return false, err
+		} else if eq {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// arith applies an arithmetic op. Integers stay integers, any other mix of
+// numbers is calculated as float64, and strings can only be concatenated.
+func arith(a, b any, op int) (any, error) {
+	if v1, is := a.(string); is {
+		if v2, is := b.(string); is && op == TOKEN_OP_ADD {
+			return v1 + v2, nil
+		}
+		return nil, ErrTypeNotMatched
+	}
+	v1, is1 := toNumber(a)
+	v2, is2 := toNumber(b)
+	if !is1 || !is2 {
+		return nil, ErrTypeNotMatched
+	}
+	if v1.kind == numInt && v2.kind == numInt {
+		if r, err := arithInt(v1.i, v2.i, op); err != nil {
+			return nil, err
+		} else if int64(int(r)) == r {
+			return int(r), nil
+		} else {
+			return r, nil
+		}
+	}
+	return arithFloat(v1.float(), v2.float(), op)
+}
+
+// arithInt applies op on a and b, a result overflowing int64 is an
+// ErrNumberOutOfRange like an overflowing literal.
+func arithInt(a, b int64, op int) (int64, error) {
+	switch op {
+	case TOKEN_OP_ADD:
+		if b > 0 && a > math.MaxInt64-b || b < 0 && a < math.MinInt64-b {
+			return 0, ErrNumberOutOfRange
+		}
+		return a + b, nil
+	case TOKEN_OP_SUB:
+		if b < 0 && a > math.MaxInt64+b || b > 0 && a < math.MinInt64+b {
+			return 0, ErrNumberOutOfRange
+		}
+		return a - b, nil
+	case TOKEN_OP_MUL:
+		if a == 0 || b == 0 {
+			return 0, nil
+		}
+		r := a * b
+		if r/b != a || a == -1 && b == math.MinInt64 || b == -1 && a == math.MinInt64 {
+			return 0, ErrNumberOutOfRange
+		}
+		return r, nil
+	case TOKEN_OP_DIV:
+		if b == 0 {
+			return 0, ErrDivisionByZero
+		} else if a == math.MinInt64 && b == -1 {
+			return 0, ErrNumberOutOfRange
+		}
+		return a / b, nil
+	case TOKEN_OP_MOD:
+		if b == 0 {
+			return 0, ErrDivisionByZero
+		}
+		return a % b, nil
+	default:
+		panic("invalid arith op")
+	}
+}
+
+func arithFloat(a, b float64, op int) (float64, error) {
+	switch op {
+	case TOKEN_OP_ADD:
+		return a + b, nil
+	case TOKEN_OP_SUB:
+		return a - b, nil
+	case TOKEN_OP_MUL:
+		return a * b, nil
+	case TOKEN_OP_DIV:
+		if b == 0 {
+			return 0, ErrDivisionByZero
+		}
+		return a / b, nil
+	case TOKEN_OP_MOD:
+		if b == 0 {
+			return 0, ErrDivisionByZero
+		}
+		return math.Mod(a, b), nil
+	default:
+		panic("invalid arith op")
+	}
+}
+
+// matchRegexp matches s against a pattern only known when evaluating, the
+// compiled regexp is cached by the pattern.
+func matchRegexp(s, pattern any, not bool) (bool, error) {
+	str, is1 := s.(string)
+	pat, is2 := pattern.(string)
+	if !is1 || !is2 {
+		return false, ErrTypeNotMatched
+	}
+	re, err := regexps.get(pat)
+	if err != nil {
+		return false, err
+	}
+	return re.MatchString(str) != not, nil
+}
+
+func matchStr(s, pattern string, op int) bool {
+	switch op {
+	case TOKEN_OP_LIKE:
+		return wildcardMatch(s, pattern, '%', '_')
+	case TOKEN_OP_ILIKE:
+		return wildcardMatch(strings.ToLower(s), pattern, '%', '_')
+	case TOKEN_OP_GLOB:
+		return wildcardMatch(s, pattern, '*', '?')
+	case TOKEN_OP_STARTS:
+		return strings.HasPrefix(s, pattern)
+	case TOKEN_OP_ENDS:
+		return strings.HasSuffix(s, pattern)
+	case TOKEN_OP_CONTAINS:
+		return strings.Contains(s, pattern)
+	default:
+		panic("invalid match op")
+	}
+}
+
+// wildcardMatch matches s against a pattern in which many matches any number
+// of characters and one matches exactly one. A backslash makes the next
+// character in the pattern match literally.
+func wildcardMatch(s, pattern string, many, one rune) bool {
+	si, pi := 0, 0
+	// where the last many was seen, and where s was then
+	starP, starS := -1, 0
+	for si < len(s) {
+		if pi < len(pattern) {
+			pc, pw := utf8.DecodeRuneInString(pattern[pi:])
+			sc, sw := utf8.DecodeRuneInString(s[si:])
+			switch {
+			case pc == many:
+				starP, starS = pi, si
+				pi += pw
+				continue
+			case pc == one:
+				si, pi = si+sw, pi+pw
+				continue
+			case pc == '\\' && pi+pw < len(pattern):
+				if ec, ew := utf8.DecodeRuneInString(pattern[pi+pw:]); ec == sc {
+					si, pi = si+sw, pi+pw+ew
+					continue
+				}
+			case pc == sc:
+				si, pi = si+sw, pi+pw
+				continue
+			}
+		}
+		if starP < 0 {
+			return false
+		}
+		// let the last many take one more character and try again
+		_, sw := utf8.DecodeRuneInString(s[starS:])
+		starS += sw
+		si, pi = starS, starP+utf8.RuneLen(many)
+	}
+	for pi < len(pattern) {
+		if pc, pw := utf8.DecodeRuneInString(pattern[pi:]); pc == many {
+			pi += pw
+		} else {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,222 @@
+package filterql
+
+// The calls of methods are unexported generic nodes, they are inspected
+// through the interfaces below. A literal argument is given as a *Literal.
+
+// MethodCall is a call of a method of ParseConfig by its name.
+type MethodCall interface {
+	Call
+	Name() string
+	Args() []EvalAst
+	// Negated tells if the result of the call is negated as a condition.
+	Negated() bool
+}
+
+// CallCompare is a comparison of a call with a literal argument to a
+// literal, which Parse merges into a single node.
+type CallCompare interface {
+	BoolAst
+	Name() string
+	Args() []EvalAst
+	Op() int
+	Target() any
+}
+
+// CallIn is a call with a literal argument checked against a list of
+// literals, which Parse merges into a single node.
+type CallIn interface {
+	BoolAst
+	Name() string
+	Args() []EvalAst
+	Choices() []any
+	Negated() bool
+}
+
+func (c *callee[T]) Name() string {
+	return c.name
+}
+
+func (c *call[T]) Args() []EvalAst {
+	if c.argCall != nil {
+		return []EvalAst{c.argCall}
+	}
+	return []EvalAst{&Literal{Value: c.arg}}
+}
+
+func (c *call[T]) Negated() bool {
+	return c.not
+}
+
+func (c *funcCall) Args() []EvalAst {
+	return c.args
+}
+
+func (c *funcCall) Negated() bool {
+	return c.not
+}
+
+func (c *callThenCompare[T1, T2]) Args() []EvalAst {
+	return []EvalAst{&Literal{Value: c.arg}}
+}
+
+func (c *callThenCompare[T1, T2]) Op() int {
+	return c.op
+}
+
+func (c *callThenCompare[T1, T2]) Target() any {
+	return c.target
+}
+
+func (c *callThenIn[T1, T2]) Args() []EvalAst {
+	return []EvalAst{&Literal{Value: c.arg}}
+}
+
+func (c *callThenIn[T1, T2]) Choices() []any {
+	return anySlice(c.choices)
+}
+
+func (c *callThenIn[T1, T2]) Negated() bool {
+	return c.not
+}
+
+// Negated tells if the result of the operation is negated as a condition.
+func (a *Arith) Negated() bool {
+	return a.not
+}
+
+// Negated tells if the value of the field is negated as a condition.
+func (f *Field) Negated() bool {
+	return f.not
+}
+
+// TargetValue returns Target as any.
+func (c *Compare[T]) TargetValue() any {
+	return c.Target
+}
+
+// ChoiceValues returns Choices as a slice of any.
+func (c *In[T]) ChoiceValues() []any {
+	return anySlice(c.Choices)
+}
+
+func anySlice[T TArg](values []T) []any {
+	list := make([]any, len(values))
+	for i, v := range values {
+		list[i] = v
+	}
+	return list
+}
+
+// A Visitor's Visit method is invoked for each node encountered by Walk. If
+// the result visitor w is not nil, Walk visits each of the children of node
+// with w, followed by a call of w.Visit(nil).
+type Visitor interface {
+	Visit(node PrintableAst) (w Visitor)
+}
+
+// Walk traverses a filter in depth-first order: it starts by calling
+// v.Visit(node); node must not be nil. The conditions, the operands and the
+// arguments of calls are all children, literal arguments included.
+func Walk(v Visitor, node PrintableAst) {
+	if v = v.Visit(node); v == nil {
+		return
+	}
+	if p, is := node.(parent); is {
+		for _, child := range p.children() {
+			Walk(v, child)
+		}
+	}
+	v.Visit(nil)
+}
+
+type inspector func(PrintableAst) bool
+
+func (f inspector) Visit(node PrintableAst) Visitor {
+	if f(node) {
+		return f
+	}
+	return nil
+}
+
+// Inspect traverses a filter in depth-first order: it starts by calling
+// f(node); node must not be nil. If f returns true, Inspect invokes f
+// recursively for each of the children of node, followed by a call of
+// f(nil).
+func Inspect(node PrintableAst, f func(PrintableAst) bool) {
+	Walk(inspector(f), node)
+}
+
+// parent is a node with children. rebuild returns a copy of the node with
+// the given children, in the order of children().
+type parent interface {
+	children() []PrintableAst
+	rebuild(children []PrintableAst) (PrintableAst, error)
+}
+
+func (a *ANDs) children() []PrintableAst {
+	return nodeList(a.Children)
+}
+
+func (a *ORs) children() []PrintableAst {
+	return nodeList(a.Children)
+}
+
+func (a *NOT) children() []PrintableAst {
+	return []PrintableAst{a.Child}
+}
+
+func (c *call[T]) children() []PrintableAst {
+	return nodeList(c.Args())
+}
+
+func (c *funcCall) children() []PrintableAst {
+	return nodeList(c.args)
+}
+
+func (a *Arith) children() []PrintableAst {
+	return []PrintableAst{a.Left, a.Right}
+}
+
+func (c *IsNull) children() []PrintableAst {
+	return []PrintableAst{c.Call}
+}
+
+func (c *Compare[T]) children() []PrintableAst {
+	return []PrintableAst{c.Call}
+}
+
+func (c *StrMatch) children() []PrintableAst {
+	return []PrintableAst{c.Call}
+}
+
+func (c *Between) children() []PrintableAst {
+	return []PrintableAst{c.Call, c.Lower, c.Upper}
+}
+
+func (c *In[T]) children() []PrintableAst {
+	return []PrintableAst{c.Call}
+}
+
+func (c *CompareWithCall) children() []PrintableAst {
+	return []PrintableAst{c.Left, c.Right}
+}
+
+func (c *InWithCall) children() []PrintableAst {
+	return []PrintableAst{c.Left, c.Right}
+}
+
+func (c *callThenCompare[T1, T2]) children() []PrintableAst {
+	return nodeList(c.Args())
+}
+
+func (c *callThenIn[T1, T2]) children() []PrintableAst {
+	return nodeList(c.Args())
+}
+
+func nodeList[T PrintableAst](nodes []T) []PrintableAst {
+	list := make([]PrintableAst, len(nodes))
+	for i, n := range nodes {
+		list[i] = n
+	}
+	return list
+}
@@ -0,0 +1,123 @@
+package filterql_test
+
+import (
+	"strings"
+	"testing"
+
+	fql "github.com/lennon-guan/filterql"
+)
+
+// recFields lists the fields read by rec(...) in a filter.
+func recFields(cond fql.BoolAst) []string {
+	var fields []string
+	fql.Inspect(cond, func(node fql.PrintableAst) bool {
+		var args []fql.EvalAst
+		switch c := node.(type) {
+		case fql.MethodCall:
+			if c.Name() == "rec" {
+				args = c.Args()
+			}
+		case fql.CallCompare:
+			if c.Name() == "rec" {
+				args = c.Args()
+			}
+		case fql.CallIn:
+			if c.Name() == "rec" {
+				args = c.Args()
+			}
+		}
+		for _, arg := range args {
+			if lit, is := arg.(*fql.Literal); is {
+				fields = append(fields, lit.Value.(string))
+			}
+		}
+		return true
+	})
+	return fields
+}
+
+func TestWalk(t *testing.T) {
+	for query, expected := range map[string]string{
+		"rec('ID') = 1": "ID",
+		"rec('Source') in (1, 3) and rec('ID') <> 2":                           "Source,ID",
+		"not (rec('Name') like 'A%' or rec('Level') between rec('ID') and 10)": "Name,Level,ID",
+		"double(len(rec('Name'))) > rec('Score') + 1":                          "Name,Score",
+		"lower(rec('Name')) in arg('names') or opt('Name') is null":            "Name",
+		"bucket('ID', 3) = one()":                                              "",
+	} {
+		cond, err := fql.Parse(query, cfg)
+		if err != nil {
+			t.Errorf("parse %s error %+v", query, err)
+			continue
+		}
+		if got := strings.Join(recFields(cond), ","); got != expected {
+			t.Errorf("%s expected %s but got %s", query, expected, got)
+		}
+	}
+}
+
+type callCounter struct {
+	calls map[string]int
+	depth int
+}
+
+func (c *callCounter) Visit(node fql.PrintableAst) fql.Visitor {
+	if node == nil {
+		c.depth--
+		return nil
+	}
+	c.depth++
+	if m, is := node.(fql.MethodCall); is {
+		c.calls[m.Name()]++
+		// the arguments are not counted
+		c.depth--
+		return nil
+	}
+	return c
+}
+
+func TestWalkSkip(t *testing.T) {
+	cond, err := fql.Parse("arg('uid') = rec('ID') or double(double(rec('Level'))) > 2 and not Name = 'Fig'", cfg)
+	if err != nil {
+		t.Fatalf("parse error %+v", err)
+	}
+	counter := &callCounter{calls: map[string]int{}}
+	fql.Walk(counter, cond)
+	if counter.depth != 0 {
+		t.Errorf("expected depth 0 after walk but got %d", counter.depth)
+	}
+	if counter.calls["arg"] != 1 || counter.calls["rec"] != 1 || counter.calls["double"] != 1 {
+		t.Errorf("unexpected calls %v", counter.calls)
+	}
+	fields := 0
+	fql.Inspect(cond, func(node fql.PrintableAst) bool {
+		if f, is := node.(*fql.Field); is && f.Path[0].Name == "Name" {
+			fields++
+		}
+		return true
+	})
+	if fields != 1 {
+		t.Errorf("expected 1 field but got %d", fields)
+	}
+}
+
+func TestAccessors(t *testing.T) {
+	cond, err := fql.Parse("rec('Level') >= 8 and not rec('Source') in (1, 2) and not Level", cfg)
+	if err != nil {
+		t.Fatalf("parse error %+v", err)
+	}
+	children := cond.(*fql.ANDs).Children
+	if c, is := children[0].(fql.CallCompare); !is {
+		t.Errorf("expected CallCompare but got %T", children[0])
+	} else if c.Op() != fql.TOKEN_OP_GE || c.Target() != 8 {
+		t.Errorf("unexpected op %d target %v", c.Op(), c.Target())
+	}
+	if c, is := children[1].(fql.CallIn); !is {
+		t.Errorf("expected CallIn but got %T", children[1])
+	} else if !c.Negated() || len(c.Choices()) != 2 || c.Choices()[1] != 2 {
+		t.Errorf("unexpected choices %v negated %v", c.Choices(), c.Negated())
+	}
+	if f, is := children[2].(*fql.Field); !is || !f.Negated() {
+		t.Errorf("expected negated field but got %s", children[2])
+	}
+}